@@ -0,0 +1,249 @@
+package phpserialize
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Canonicalize returns data in a canonical form, in which values PHP
+// unserializes to the same thing are written the same way. Array keys are
+// put in the order of PHP's ksort, as the Encoder writes maps, with
+// integer-like string keys turned into integers. Object properties are
+// ordered the same way, with integer names turned into strings. Integers
+// and floats are rewritten the way the Encoder writes them. Of duplicate
+// keys only the last is kept, as in PHP.
+//
+// References are renumbered to match the new order. It is an error when
+// reordering would leave a reference in front of the value it refers to.
+// Strings and the payloads of C: objects are left untouched.
+func Canonicalize(data []byte) ([]byte, error) {
+	var v Value
+	if err := Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	c := canonicalizer{refs: make(map[*Value]*Value), slots: make(map[*Value]int)}
+	if err := c.resolve(&v); err != nil {
+		return nil, err
+	}
+	c.normalize(&v)
+	if err := c.renumber(&v); err != nil {
+		return nil, err
+	}
+	return Marshal(&v)
+}
+
+// Equal reports whether a and b unserialize to the same value in PHP.
+// Arrays and objects are equal when they hold equal values under the same
+// keys in any order, with integer-like string keys matching integer keys
+// and the last of duplicate keys counting, as in PHP. Numbers are equal
+// when their values are, however they are written, and references when
+// they point at values in the same place.
+func Equal(a, b []byte) (bool, error) {
+	var va, vb Value
+	if err := Unmarshal(a, &va); err != nil {
+		return false, err
+	}
+	if err := Unmarshal(b, &vb); err != nil {
+		return false, err
+	}
+	ca := canonicalizer{refs: make(map[*Value]*Value)}
+	if err := ca.resolve(&va); err != nil {
+		return false, err
+	}
+	cb := canonicalizer{refs: make(map[*Value]*Value)}
+	if err := cb.resolve(&vb); err != nil {
+		return false, err
+	}
+
+	q := equality{refsA: ca.refs, refsB: cb.refs, pairs: make(map[*Value]*Value), paired: make(map[*Value]bool)}
+	return q.equal(&va, &vb) && q.refsEqual(), nil
+}
+
+// equality compares two decoded values along with the references between
+// their parts.
+type equality struct {
+	refsA, refsB map[*Value]*Value
+	// pairs maps the parts of the first value to the parts of the second
+	// they were compared with, and paired holds the latter.
+	pairs  map[*Value]*Value
+	paired map[*Value]bool
+	// refs holds the targets of the pairs of references met, which are
+	// checked once all parts are paired.
+	refs [][2]*Value
+}
+
+func (q *equality) equal(a, b *Value) bool {
+	if a.code != b.code && a.Kind() != b.Kind() || a.class != b.class {
+		return false
+	}
+	q.pairs[a] = b
+	q.paired[b] = true
+
+	switch a.code {
+	case 'r', 'R':
+		q.refs = append(q.refs, [2]*Value{q.refsA[a], q.refsB[b]})
+		return a.code == b.code
+	case 'i':
+		return a.Int() == b.Int()
+	case 'd':
+		fa, fb := a.Float(), b.Float()
+		return fa == fb || math.IsNaN(fa) && math.IsNaN(fb)
+	case 'a', 'O':
+		object := a.code == 'O'
+		ea, eb := lastElements(a, object), lastElements(b, object)
+		if len(ea) != len(eb) {
+			return false
+		}
+		for key, elem := range ea {
+			other, ok := eb[key]
+			if !ok || !q.equal(elem, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return a.text == b.text
+}
+
+// refsEqual reports whether the pairs of references met point at parts
+// that were compared with each other.
+func (q *equality) refsEqual() bool {
+	for i := 0; i < len(q.refs); i++ {
+		a, b := q.refs[i][0], q.refs[i][1]
+		if other, ok := q.pairs[a]; ok {
+			if other != b {
+				return false
+			}
+			continue
+		}
+		// Neither target was compared when a later duplicate key dropped
+		// it, so compare it now.
+		if q.paired[b] || !q.equal(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// lastElements returns the elements of an array or object by the key PHP
+// stores them under, keeping the last of duplicate keys.
+func lastElements(v *Value, object bool) map[phpKey]*Value {
+	elems := make(map[phpKey]*Value, len(v.keys))
+	for i := range v.keys {
+		elems[elementKey(v.keys[i], object)] = v.elems[i]
+	}
+	return elems
+}
+
+type canonicalizer struct {
+	// values holds the values a reference may point at in input order,
+	// like the variable table PHP's unserialize keeps.
+	values []*Value
+	// refs maps references to the values they point at.
+	refs map[*Value]*Value
+	// slots holds the new reference numbers of the values written so far.
+	slots map[*Value]int
+}
+
+// resolve finds the values the references within v point at.
+func (c *canonicalizer) resolve(v *Value) error {
+	if v.code == 'r' || v.code == 'R' {
+		n, _ := strconv.Atoi(v.text)
+		if n < 1 || n > len(c.values) {
+			return fmt.Errorf(`phpserialize: Decode(reference %d out of range)`, n)
+		}
+		c.refs[v] = c.values[n-1]
+	}
+	if v.code != 'R' {
+		c.values = append(c.values, v)
+	}
+	for _, elem := range v.elems {
+		if err := c.resolve(elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *canonicalizer) normalize(v *Value) {
+	switch v.code {
+	case 'i':
+		n, _ := strconv.ParseInt(v.text, 10, 64)
+		v.text = strconv.FormatInt(n, 10)
+	case 'd':
+		f, _ := strconv.ParseFloat(v.text, 64)
+		v.text = formatFloat(f)
+	case 'a', 'O':
+		for _, elem := range v.elems {
+			c.normalize(elem)
+		}
+		sortElements(v)
+	}
+}
+
+// sortElements normalizes the keys of an array or object and sorts its
+// elements by them, dropping all but the last of duplicate keys.
+func sortElements(v *Value) {
+	keys := make([]phpKey, len(v.keys))
+	last := make(map[phpKey]int, len(v.keys))
+	for i := range v.keys {
+		key := &v.keys[i]
+		if key.code == 'i' {
+			n, _ := strconv.ParseInt(key.text, 10, 64)
+			key.text = strconv.FormatInt(n, 10)
+			if v.code == 'O' {
+				key.code = 's'
+			}
+		} else if n, ok := integerKey(key.text); ok && v.code == 'a' {
+			key.code = 'i'
+			key.text = strconv.FormatInt(n, 10)
+		}
+
+		if key.code == 'i' {
+			keys[i].num, _ = strconv.ParseInt(key.text, 10, 64)
+		} else {
+			keys[i].isStr, keys[i].str = true, key.text
+		}
+		last[keys[i]] = i
+	}
+
+	sortedKeys := make([]Value, 0, len(last))
+	sortedElems := make([]*Value, 0, len(last))
+	for _, i := range ksortOrder(keys) {
+		if last[keys[i]] == i {
+			sortedKeys = append(sortedKeys, v.keys[i])
+			sortedElems = append(sortedElems, v.elems[i])
+		}
+	}
+	v.keys, v.elems = sortedKeys, sortedElems
+}
+
+// renumber rewrites the references within v for the new order of values.
+func (c *canonicalizer) renumber(v *Value) error {
+	if v.code == 'r' || v.code == 'R' {
+		n, ok := c.slots[c.refs[v]]
+		if !ok {
+			return fmt.Errorf(`phpserialize: Canonicalize(reference to a value that is no longer before it)`)
+		}
+		v.text = strconv.Itoa(n)
+	}
+	if v.code != 'R' {
+		c.slots[v] = len(c.slots) + 1
+	}
+	for _, elem := range v.elems {
+		if err := c.renumber(elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// integerKey reports whether PHP stores the string array key s as an
+// integer, which it does for decimal integers written without leading
+// zeros or a plus sign that fit an int64.
+func integerKey(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil && strconv.FormatInt(n, 10) == s
+}
@@ -0,0 +1,120 @@
+package example
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zarken-go/phpserialize"
+)
+
+// plainUser has the fields of User without its generated methods, so it is
+// encoded and decoded through reflection.
+type plainUser User
+
+func testUser() User {
+	nickname := `jd`
+	updated := time.Date(2021, 5, 3, 12, 30, 15, 0, time.UTC)
+	return User{
+		ID:       42,
+		Name:     `Jane`,
+		Status:   `active`,
+		Admin:    true,
+		Age:      31,
+		Logins:   7,
+		Score:    9.5,
+		Nickname: &nickname,
+		Created:  time.Unix(1620045015, 0),
+		Updated:  &updated,
+		Timeout:  1500 * time.Millisecond,
+		Interval: time.Minute,
+		Address:  Address{Street: `Main St`, Zip: 1234},
+		Manager:  &Address{Street: `Side St`, Zip: 5678},
+		Tags:     []string{`a`, `b`},
+		Meta:     map[string]string{`k`: `v`},
+		Secret:   `hidden`,
+		Untagged: 3,
+		Extra:    int64(5),
+	}
+}
+
+func TestGeneratedMatchesReflection(t *testing.T) {
+	// Without the nil slice and map, which encode as N; that only decodes
+	// into a pointer or interface.
+	empty := User{Tags: []string{}, Meta: map[string]string{}}
+	for _, u := range []User{testUser(), empty} {
+		generated, err := phpserialize.Marshal(u)
+		assert.Nil(t, err)
+		reflected, err := phpserialize.Marshal(plainUser(u))
+		assert.Nil(t, err)
+		assert.Equal(t, string(reflected), string(generated))
+
+		var fromGenerated User
+		var fromReflection plainUser
+		assert.Nil(t, phpserialize.Unmarshal(generated, &fromGenerated))
+		assert.Nil(t, phpserialize.Unmarshal(generated, &fromReflection))
+		assert.Equal(t, User(fromReflection), fromGenerated)
+	}
+}
+
+func TestGeneratedMethods(t *testing.T) {
+	u := testUser()
+	data, err := u.MarshalPHP()
+	assert.Nil(t, err)
+
+	var decoded User
+	assert.Nil(t, decoded.UnmarshalPHP(data))
+	assert.Equal(t, u.Name, decoded.Name)
+	assert.Equal(t, *u.Manager, *decoded.Manager)
+	assert.Equal(t, ``, decoded.Secret)
+
+	// Objects decode too, with their property names unmangled.
+	var a Address
+	assert.Nil(t, phpserialize.UnmarshalString(`O:7:"Address":2:{s:9:"`+"\x00*\x00"+`street";s:1:"x";s:5:"other";i:1;}`, &a))
+	assert.Equal(t, Address{Street: `x`}, a)
+
+	err = phpserialize.UnmarshalString(`a:1:{s:3:"age";i:300;}`, &decoded)
+	assert.EqualError(t, err, `phpserialize: cannot unmarshal integer 300 into Go field User.Age of type uint8`)
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	u := testUser()
+	b.Run(`Generated`, func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := phpserialize.Marshal(u); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run(`Reflection`, func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := phpserialize.Marshal(plainUser(u)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	data, _ := phpserialize.Marshal(testUser())
+	b.Run(`Generated`, func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var u User
+			if err := phpserialize.Unmarshal(data, &u); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run(`Reflection`, func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var u plainUser
+			if err := phpserialize.Unmarshal(data, &u); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
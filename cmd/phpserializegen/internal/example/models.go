@@ -0,0 +1,41 @@
+// Package example holds structs with methods generated by phpserializegen,
+// which its tests compare to the reflection based encoding.
+package example
+
+import (
+	stdtime "time"
+)
+
+//go:generate go run github.com/zarken-go/phpserialize/cmd/phpserializegen
+
+// Status is a named basic type.
+type Status string
+
+//phpserialize:generate
+type User struct {
+	ID       int64             `php:"id"`
+	Name     string            `php:"name"`
+	Status   Status            `php:"status"`
+	Admin    bool              `php:"admin"`
+	Age      uint8             `php:"age"`
+	Logins   uint              `php:"logins"`
+	Score    float32           `php:"score"`
+	Nickname *string           `php:"nickname"`
+	Created  stdtime.Time      `php:"created,unix"`
+	Updated  *stdtime.Time     `php:"updated"`
+	Timeout  stdtime.Duration  `php:"timeout,seconds"`
+	Interval stdtime.Duration  `php:"interval"`
+	Address  Address           `php:"address"`
+	Manager  *Address          `php:"manager"`
+	Tags     []string          `php:"tags"`
+	Meta     map[string]string `php:"meta"`
+	Secret   string            `php:"-"`
+	Untagged int
+	Extra    interface{} `php:"extra"`
+}
+
+//phpserialize:generate
+type Address struct {
+	Street string `php:"street"`
+	Zip    int    `php:"zip"`
+}
@@ -0,0 +1,349 @@
+// Code generated by phpserializegen. DO NOT EDIT.
+
+package example
+
+import (
+	"github.com/zarken-go/phpserialize"
+	"math/bits"
+	"reflect"
+	stdtime "time"
+)
+
+// EncodePHP implements phpserialize.CustomEncoder.
+func (x User) EncodePHP(e *phpserialize.Encoder) error {
+	if err := e.EncodeStructLen(reflect.TypeOf((*User)(nil)).Elem(), 18); err != nil {
+		return err
+	}
+	if err := e.EncodeString("id"); err != nil {
+		return err
+	}
+	if err := e.EncodeInt64(x.ID); err != nil {
+		return err
+	}
+	if err := e.EncodeString("name"); err != nil {
+		return err
+	}
+	if err := e.EncodeString(x.Name); err != nil {
+		return err
+	}
+	if err := e.EncodeString("status"); err != nil {
+		return err
+	}
+	if err := e.EncodeString(string(x.Status)); err != nil {
+		return err
+	}
+	if err := e.EncodeString("admin"); err != nil {
+		return err
+	}
+	if err := e.EncodeBool(x.Admin); err != nil {
+		return err
+	}
+	if err := e.EncodeString("age"); err != nil {
+		return err
+	}
+	if err := e.EncodeUint64(uint64(x.Age)); err != nil {
+		return err
+	}
+	if err := e.EncodeString("logins"); err != nil {
+		return err
+	}
+	if err := e.EncodeUint64(uint64(x.Logins)); err != nil {
+		return err
+	}
+	if err := e.EncodeString("score"); err != nil {
+		return err
+	}
+	if err := e.EncodeFloat64(float64(x.Score)); err != nil {
+		return err
+	}
+	if err := e.EncodeString("nickname"); err != nil {
+		return err
+	}
+	if x.Nickname == nil {
+		if err := e.EncodeNil(); err != nil {
+			return err
+		}
+	} else if err := e.EncodeString(*x.Nickname); err != nil {
+		return err
+	}
+	if err := e.EncodeString("created"); err != nil {
+		return err
+	}
+	if err := e.EncodeTimeAs(x.Created, phpserialize.TimeAsUnix); err != nil {
+		return err
+	}
+	if err := e.EncodeString("updated"); err != nil {
+		return err
+	}
+	if x.Updated == nil {
+		if err := e.EncodeNil(); err != nil {
+			return err
+		}
+	} else if err := e.EncodeTime(*x.Updated); err != nil {
+		return err
+	}
+	if err := e.EncodeString("timeout"); err != nil {
+		return err
+	}
+	if err := e.EncodeDurationSeconds(x.Timeout); err != nil {
+		return err
+	}
+	if err := e.EncodeString("interval"); err != nil {
+		return err
+	}
+	if err := e.EncodeInt64(int64(x.Interval)); err != nil {
+		return err
+	}
+	if err := e.EncodeString("address"); err != nil {
+		return err
+	}
+	if err := x.Address.EncodePHP(e); err != nil {
+		return err
+	}
+	if err := e.EncodeString("manager"); err != nil {
+		return err
+	}
+	if x.Manager == nil {
+		if err := e.EncodeNil(); err != nil {
+			return err
+		}
+	} else if err := x.Manager.EncodePHP(e); err != nil {
+		return err
+	}
+	if err := e.EncodeString("tags"); err != nil {
+		return err
+	}
+	if err := e.EncodeValue(reflect.ValueOf(&x.Tags).Elem()); err != nil {
+		return err
+	}
+	if err := e.EncodeString("meta"); err != nil {
+		return err
+	}
+	if err := e.EncodeValue(reflect.ValueOf(&x.Meta).Elem()); err != nil {
+		return err
+	}
+	if err := e.EncodeString("Untagged"); err != nil {
+		return err
+	}
+	if err := e.EncodeInt64(int64(x.Untagged)); err != nil {
+		return err
+	}
+	if err := e.EncodeString("extra"); err != nil {
+		return err
+	}
+	if err := e.EncodeValue(reflect.ValueOf(&x.Extra).Elem()); err != nil {
+		return err
+	}
+	return e.EncodeArrayEnd()
+}
+
+// DecodePHP implements phpserialize.CustomDecoder.
+func (x *User) DecodePHP(d *phpserialize.Decoder) error {
+	n, err := d.DecodeStructLen()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		name, err := d.DecodePropertyName()
+		if err != nil {
+			return err
+		}
+		switch name {
+		case "id":
+			x.ID, err = d.DecodeInt64()
+			if err != nil {
+				return phpserialize.WithField(err, "ID")
+			}
+		case "name":
+			x.Name, err = d.DecodeString()
+			if err != nil {
+				return phpserialize.WithField(err, "Name")
+			}
+		case "status":
+			v, err := d.DecodeString()
+			if err != nil {
+				return phpserialize.WithField(err, "Status")
+			}
+			x.Status = Status(v)
+		case "admin":
+			x.Admin, err = d.DecodeBool()
+			if err != nil {
+				return phpserialize.WithField(err, "Admin")
+			}
+		case "age":
+			v, err := d.DecodeUnsignedInt(8)
+			if err != nil {
+				return phpserialize.WithField(err, "Age")
+			}
+			x.Age = uint8(v)
+		case "logins":
+			v, err := d.DecodeUnsignedInt(bits.UintSize)
+			if err != nil {
+				return phpserialize.WithField(err, "Logins")
+			}
+			x.Logins = uint(v)
+		case "score":
+			x.Score, err = d.DecodeFloat32()
+			if err != nil {
+				return phpserialize.WithField(err, "Score")
+			}
+		case "nickname":
+			if code, _ := d.PeekCode(); code == 'N' {
+				x.Nickname = nil
+				if err := d.DecodeNil(); err != nil {
+					return err
+				}
+			} else {
+				if x.Nickname == nil {
+					x.Nickname = new(string)
+				}
+				*x.Nickname, err = d.DecodeString()
+				if err != nil {
+					return phpserialize.WithField(err, "Nickname")
+				}
+			}
+		case "created":
+			x.Created, err = d.DecodeTime()
+			if err != nil {
+				return phpserialize.WithField(err, "Created")
+			}
+		case "updated":
+			if code, _ := d.PeekCode(); code == 'N' {
+				x.Updated = nil
+				if err := d.DecodeNil(); err != nil {
+					return err
+				}
+			} else {
+				if x.Updated == nil {
+					x.Updated = new(stdtime.Time)
+				}
+				*x.Updated, err = d.DecodeTime()
+				if err != nil {
+					return phpserialize.WithField(err, "Updated")
+				}
+			}
+		case "timeout":
+			x.Timeout, err = d.DecodeDurationSeconds()
+			if err != nil {
+				return phpserialize.WithField(err, "Timeout")
+			}
+		case "interval":
+			v, err := d.DecodeInt64()
+			if err != nil {
+				return phpserialize.WithField(err, "Interval")
+			}
+			x.Interval = stdtime.Duration(v)
+		case "address":
+			if err := x.Address.DecodePHP(d); err != nil {
+				return phpserialize.WithField(err, "Address")
+			}
+		case "manager":
+			if code, _ := d.PeekCode(); code == 'N' {
+				x.Manager = nil
+				if err := d.DecodeNil(); err != nil {
+					return err
+				}
+			} else {
+				if x.Manager == nil {
+					x.Manager = new(Address)
+				}
+				if err := x.Manager.DecodePHP(d); err != nil {
+					return phpserialize.WithField(err, "Manager")
+				}
+			}
+		case "tags":
+			if err := d.DecodeValue(reflect.ValueOf(&x.Tags).Elem()); err != nil {
+				return phpserialize.WithField(err, "Tags")
+			}
+		case "meta":
+			if err := d.DecodeValue(reflect.ValueOf(&x.Meta).Elem()); err != nil {
+				return phpserialize.WithField(err, "Meta")
+			}
+		case "Untagged":
+			x.Untagged, err = d.DecodeInt()
+			if err != nil {
+				return phpserialize.WithField(err, "Untagged")
+			}
+		case "extra":
+			if err := d.DecodeValue(reflect.ValueOf(&x.Extra).Elem()); err != nil {
+				return phpserialize.WithField(err, "Extra")
+			}
+		default:
+			if err := d.SkipProperty(name); err != nil {
+				return err
+			}
+		}
+	}
+	return d.DecodeArrayEnd()
+}
+
+// MarshalPHP implements phpserialize.Marshaler.
+func (x User) MarshalPHP() ([]byte, error) {
+	return phpserialize.Marshal(x)
+}
+
+// UnmarshalPHP implements phpserialize.Unmarshaler.
+func (x *User) UnmarshalPHP(data []byte) error {
+	return phpserialize.Unmarshal(data, x)
+}
+
+// EncodePHP implements phpserialize.CustomEncoder.
+func (x Address) EncodePHP(e *phpserialize.Encoder) error {
+	if err := e.EncodeStructLen(reflect.TypeOf((*Address)(nil)).Elem(), 2); err != nil {
+		return err
+	}
+	if err := e.EncodeString("street"); err != nil {
+		return err
+	}
+	if err := e.EncodeString(x.Street); err != nil {
+		return err
+	}
+	if err := e.EncodeString("zip"); err != nil {
+		return err
+	}
+	if err := e.EncodeInt64(int64(x.Zip)); err != nil {
+		return err
+	}
+	return e.EncodeArrayEnd()
+}
+
+// DecodePHP implements phpserialize.CustomDecoder.
+func (x *Address) DecodePHP(d *phpserialize.Decoder) error {
+	n, err := d.DecodeStructLen()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		name, err := d.DecodePropertyName()
+		if err != nil {
+			return err
+		}
+		switch name {
+		case "street":
+			x.Street, err = d.DecodeString()
+			if err != nil {
+				return phpserialize.WithField(err, "Street")
+			}
+		case "zip":
+			x.Zip, err = d.DecodeInt()
+			if err != nil {
+				return phpserialize.WithField(err, "Zip")
+			}
+		default:
+			if err := d.SkipProperty(name); err != nil {
+				return err
+			}
+		}
+	}
+	return d.DecodeArrayEnd()
+}
+
+// MarshalPHP implements phpserialize.Marshaler.
+func (x Address) MarshalPHP() ([]byte, error) {
+	return phpserialize.Marshal(x)
+}
+
+// UnmarshalPHP implements phpserialize.Unmarshaler.
+func (x *Address) UnmarshalPHP(data []byte) error {
+	return phpserialize.Unmarshal(data, x)
+}
@@ -0,0 +1,619 @@
+// Command phpserializegen writes EncodePHP, DecodePHP, MarshalPHP and
+// UnmarshalPHP methods for structs, so that they encode and decode through
+// the phpserialize Encoder and Decoder primitives instead of reflection.
+//
+// Structs are selected with a comment in their documentation:
+//
+//	//phpserialize:generate
+//	type User struct {
+//		ID   int    `php:"id"`
+//		Name string `php:"name"`
+//	}
+//
+// and the file declaring them is processed by adding
+//
+//	//go:generate phpserializegen
+//
+// to it and running go generate. Files may also be named as arguments.
+// The methods for the structs of models.go are written to
+// models_phpserialize.go.
+//
+// The generated methods follow the php struct tags like the reflection
+// based encoder: field names, "-", the time format options and the seconds
+// option. Fields of types the generator does not handle directly, such as
+// slices, maps and types of other packages, are passed to EncodeValue and
+// DecodeValue.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/tagparser"
+)
+
+const (
+	annotation   = `//phpserialize:generate`
+	importPath   = `github.com/zarken-go/phpserialize`
+	outputSuffix = `_phpserialize.go`
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix(`phpserializegen: `)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), `usage: phpserializegen [file.go ...]`)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	files := flag.Args()
+	if len(files) == 0 {
+		if gofile := os.Getenv(`GOFILE`); gofile != `` {
+			files = []string{gofile}
+		} else {
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+
+	for _, file := range files {
+		out, err := generateFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if out == nil {
+			continue
+		}
+		name := strings.TrimSuffix(file, `.go`) + outputSuffix
+		if err := ioutil.WriteFile(name, out, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// generateFile returns the generated source for the annotated structs of
+// file, or nil when it has none. The other files of its package are read
+// to resolve the types it refers to.
+func generateFile(file string) ([]byte, error) {
+	fset := token.NewFileSet()
+	dir := filepath.Dir(file)
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), `_test.go`) && !strings.HasSuffix(fi.Name(), outputSuffix)
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		for name, f := range pkg.Files {
+			if other, err := filepath.Abs(name); err == nil && other == abs {
+				return generate(fset, pkg, f)
+			}
+		}
+	}
+	return nil, fmt.Errorf(`%s: no Go package found`, file)
+}
+
+// generate returns the generated source for the annotated structs of f,
+// one of the files of pkg, or nil when it has none.
+func generate(fset *token.FileSet, pkg *ast.Package, f *ast.File) ([]byte, error) {
+	g := newGenerator(fset, pkg)
+	g.file = f
+
+	var structs []*ast.TypeSpec
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if _, ok := ts.Type.(*ast.StructType); ok && g.annotated[ts.Name.Name] && ts.Assign == 0 {
+				structs = append(structs, ts)
+			}
+		}
+	}
+	if len(structs) == 0 {
+		return nil, nil
+	}
+
+	var body bytes.Buffer
+	for _, ts := range structs {
+		if err := g.writeStruct(&body, ts); err != nil {
+			return nil, err
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by phpserializegen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg.Name)
+	for _, path := range g.importList() {
+		fmt.Fprintf(&out, "\t%s\n", path)
+	}
+	fmt.Fprintf(&out, ")\n\n%s", body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf(`formatting generated code: %v`, err)
+	}
+	return src, nil
+}
+
+type generator struct {
+	fset *token.FileSet
+	// file is the file whose structs are generated.
+	file *ast.File
+	// annotated holds the names of the structs of the package that have
+	// generated methods.
+	annotated map[string]bool
+	// types holds the type declarations of the package.
+	types map[string]*ast.TypeSpec
+	// custom holds the names of the types of the package with hand written
+	// methods of the phpserialize interfaces.
+	custom map[string]bool
+	// imports maps the import paths used by the generated code to their
+	// names in it.
+	imports map[string]string
+}
+
+func newGenerator(fset *token.FileSet, pkg *ast.Package) *generator {
+	g := &generator{
+		fset:      fset,
+		annotated: make(map[string]bool),
+		types:     make(map[string]*ast.TypeSpec),
+		custom:    make(map[string]bool),
+		imports:   map[string]string{importPath: ``, `reflect`: ``},
+	}
+
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				if decl.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					ts := spec.(*ast.TypeSpec)
+					g.types[ts.Name.Name] = ts
+					doc := ts.Doc
+					if doc == nil && len(decl.Specs) == 1 {
+						doc = decl.Doc
+					}
+					if hasAnnotation(doc) {
+						g.annotated[ts.Name.Name] = true
+					}
+				}
+			case *ast.FuncDecl:
+				if decl.Recv == nil || len(decl.Recv.List) == 0 {
+					continue
+				}
+				switch decl.Name.Name {
+				case `EncodePHP`, `DecodePHP`, `MarshalPHP`, `UnmarshalPHP`:
+					recv := decl.Recv.List[0].Type
+					if star, ok := recv.(*ast.StarExpr); ok {
+						recv = star.X
+					}
+					if id, ok := recv.(*ast.Ident); ok {
+						g.custom[id.Name] = true
+					}
+				}
+			}
+		}
+	}
+	return g
+}
+
+func hasAnnotation(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == annotation {
+			return true
+		}
+	}
+	return false
+}
+
+// importList returns the import lines of the generated file, sorted by
+// path.
+func (g *generator) importList() []string {
+	paths := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	lines := make([]string, len(paths))
+	for i, path := range paths {
+		lines[i] = strconv.Quote(path)
+		if name := g.imports[path]; name != `` {
+			lines[i] = name + ` ` + lines[i]
+		}
+	}
+	return lines
+}
+
+// structField is a field of a generated struct.
+type structField struct {
+	// name is the property name, goName the name of the Go field.
+	name, goName string
+	typ          fieldType
+	// timeFormat names the TimeFormat constant selected by a tag option.
+	timeFormat string
+	// seconds is set by the seconds tag option.
+	seconds bool
+}
+
+// fieldType describes how a field type is encoded and decoded.
+type fieldType struct {
+	// kind is a basic type name such as int64, time, duration, custom for
+	// the structs with generated methods, or empty for types left to
+	// reflection.
+	kind string
+	// expr is the type of the value, without the pointer when ptr is set.
+	expr string
+	ptr  bool
+	// imports maps the import paths expr refers to to their names.
+	imports map[string]string
+}
+
+// timeFormatOptions lists the time format tag options and the constants
+// they select, in the order they are looked for.
+var timeFormatOptions = [...][2]string{
+	{`datetime`, `TimeAsDateTime`},
+	{`immutable`, `TimeAsDateTimeImmutable`},
+	{`unix`, `TimeAsUnix`},
+	{`string`, `TimeAsString`},
+}
+
+func (g *generator) fields(st *ast.StructType) []structField {
+	var fields []structField
+	for _, f := range st.Fields.List {
+		var tagStr string
+		if f.Tag != nil {
+			tag, _ := strconv.Unquote(f.Tag.Value)
+			tagStr = reflect.StructTag(tag).Get(`php`)
+		}
+		tag := tagparser.Parse(tagStr)
+		if tag.Name == `-` {
+			continue
+		}
+
+		names := f.Names
+		if len(names) == 0 {
+			// An embedded field is named after its type.
+			typ := f.Type
+			if star, ok := typ.(*ast.StarExpr); ok {
+				typ = star.X
+			}
+			if sel, ok := typ.(*ast.SelectorExpr); ok {
+				typ = sel.Sel
+			}
+			if id, ok := typ.(*ast.Ident); ok {
+				names = []*ast.Ident{id}
+			}
+		}
+
+		typ := g.fieldType(f.Type)
+		for _, id := range names {
+			if id.Name == `_` {
+				continue
+			}
+			field := structField{name: tag.Name, goName: id.Name, typ: typ}
+			if field.name == `` {
+				field.name = id.Name
+			}
+			switch typ.kind {
+			case `time`:
+				for _, opt := range timeFormatOptions {
+					if tag.HasOption(opt[0]) {
+						field.timeFormat = opt[1]
+						break
+					}
+				}
+			case `duration`:
+				field.seconds = tag.HasOption(`seconds`)
+			}
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+var basicKinds = map[string]string{
+	`string`:  `string`,
+	`bool`:    `bool`,
+	`int`:     `int`,
+	`int8`:    `int8`,
+	`int16`:   `int16`,
+	`int32`:   `int32`,
+	`rune`:    `int32`,
+	`int64`:   `int64`,
+	`uint`:    `uint`,
+	`uint8`:   `uint8`,
+	`byte`:    `uint8`,
+	`uint16`:  `uint16`,
+	`uint32`:  `uint32`,
+	`uint64`:  `uint64`,
+	`float32`: `float32`,
+	`float64`: `float64`,
+}
+
+func (g *generator) fieldType(expr ast.Expr) fieldType {
+	typ := fieldType{expr: g.typeString(expr), imports: g.typeImports(expr)}
+	if star, ok := expr.(*ast.StarExpr); ok {
+		elem := g.fieldType(star.X)
+		if elem.kind == `` || elem.ptr {
+			return typ
+		}
+		elem.ptr = true
+		return elem
+	}
+
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		typ.kind = g.identKind(expr.Name, 0)
+	case *ast.SelectorExpr:
+		if pkg, ok := expr.X.(*ast.Ident); ok && g.importPath(pkg.Name) == `time` {
+			switch expr.Sel.Name {
+			case `Time`:
+				typ.kind = `time`
+			case `Duration`:
+				typ.kind = `duration`
+			}
+		}
+	}
+	return typ
+}
+
+// identKind returns the kind of the type called name, following type
+// declarations of the package down to a basic type.
+func (g *generator) identKind(name string, depth int) string {
+	if g.annotated[name] {
+		if ts, ok := g.types[name]; ok && ts.Assign == 0 {
+			return `custom`
+		}
+	}
+	if g.custom[name] || depth > 10 {
+		return ``
+	}
+	if ts, ok := g.types[name]; ok {
+		if id, ok := ts.Type.(*ast.Ident); ok {
+			return g.identKind(id.Name, depth+1)
+		}
+		return ``
+	}
+	return basicKinds[name]
+}
+
+// importPath returns the path of the package imported as name by the
+// file being generated.
+func (g *generator) importPath(name string) string {
+	for _, spec := range g.file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		if spec.Name != nil {
+			if spec.Name.Name == name {
+				return path
+			}
+		} else if path[strings.LastIndexByte(path, '/')+1:] == name {
+			return path
+		}
+	}
+	return ``
+}
+
+// typeImports returns the imports the type expr refers to.
+func (g *generator) typeImports(expr ast.Expr) map[string]string {
+	imports := make(map[string]string)
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if pkg, ok := sel.X.(*ast.Ident); ok {
+				if path := g.importPath(pkg.Name); path != `` {
+					name := ``
+					if path[strings.LastIndexByte(path, '/')+1:] != pkg.Name {
+						name = pkg.Name
+					}
+					imports[path] = name
+				}
+			}
+			return false
+		}
+		return true
+	})
+	return imports
+}
+
+func (g *generator) typeString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	_ = printer.Fprint(&buf, g.fset, expr)
+	return buf.String()
+}
+
+// typeName returns the type of typ's value for use in the generated code.
+func (g *generator) typeName(typ fieldType) string {
+	for path, name := range typ.imports {
+		g.imports[path] = name
+	}
+	return typ.expr
+}
+
+// convert returns value, of type from, converted to typ when it differs.
+func (g *generator) convert(typ fieldType, from, value string) string {
+	if typ.expr == from {
+		return value
+	}
+	return g.typeName(typ) + `(` + value + `)`
+}
+
+func (g *generator) writeStruct(w *bytes.Buffer, ts *ast.TypeSpec) error {
+	name := ts.Name.Name
+	fields := g.fields(ts.Type.(*ast.StructType))
+
+	fmt.Fprintf(w, "// EncodePHP implements phpserialize.CustomEncoder.\n")
+	fmt.Fprintf(w, "func (x %s) EncodePHP(e *phpserialize.Encoder) error {\n", name)
+	fmt.Fprintf(w, "if err := e.EncodeStructLen(reflect.TypeOf((*%s)(nil)).Elem(), %d); err != nil {\nreturn err\n}\n", name, len(fields))
+	for _, f := range fields {
+		fmt.Fprintf(w, "if err := e.EncodeString(%s); err != nil {\nreturn err\n}\n", strconv.Quote(f.name))
+		g.writeEncodeField(w, f)
+	}
+	fmt.Fprintf(w, "return e.EncodeArrayEnd()\n}\n\n")
+
+	fmt.Fprintf(w, "// DecodePHP implements phpserialize.CustomDecoder.\n")
+	fmt.Fprintf(w, "func (x *%s) DecodePHP(d *phpserialize.Decoder) error {\n", name)
+	fmt.Fprintf(w, "n, err := d.DecodeStructLen()\nif err != nil {\nreturn err\n}\n")
+	fmt.Fprintf(w, "for i := 0; i < n; i++ {\nname, err := d.DecodePropertyName()\nif err != nil {\nreturn err\n}\nswitch name {\n")
+	// Like the reflection based decoder, the last field wins when several
+	// share a name.
+	last := make(map[string]int, len(fields))
+	for i, f := range fields {
+		last[f.name] = i
+	}
+	for i, f := range fields {
+		if last[f.name] != i {
+			continue
+		}
+		fmt.Fprintf(w, "case %s:\n", strconv.Quote(f.name))
+		g.writeDecodeField(w, f)
+	}
+	fmt.Fprintf(w, "default:\nif err := d.SkipProperty(name); err != nil {\nreturn err\n}\n}\n}\n")
+	fmt.Fprintf(w, "return d.DecodeArrayEnd()\n}\n\n")
+
+	fmt.Fprintf(w, "// MarshalPHP implements phpserialize.Marshaler.\n")
+	fmt.Fprintf(w, "func (x %s) MarshalPHP() ([]byte, error) {\nreturn phpserialize.Marshal(x)\n}\n\n", name)
+	fmt.Fprintf(w, "// UnmarshalPHP implements phpserialize.Unmarshaler.\n")
+	fmt.Fprintf(w, "func (x *%s) UnmarshalPHP(data []byte) error {\nreturn phpserialize.Unmarshal(data, x)\n}\n\n", name)
+	return nil
+}
+
+func (g *generator) writeEncodeField(w *bytes.Buffer, f structField) {
+	field := `x.` + f.goName
+	if !f.typ.ptr {
+		fmt.Fprintf(w, "if err := %s; err != nil {\nreturn err\n}\n", g.encodeCall(f, field))
+		return
+	}
+	fmt.Fprintf(w, "if %s == nil {\nif err := e.EncodeNil(); err != nil {\nreturn err\n}\n", field)
+	fmt.Fprintf(w, "} else if err := %s; err != nil {\nreturn err\n}\n", g.encodeCall(f, `*`+field))
+}
+
+// encodeCall returns the call that encodes value, a field of type f.typ
+// or the value its pointer points to.
+func (g *generator) encodeCall(f structField, value string) string {
+	switch f.typ.kind {
+	case `string`:
+		return fmt.Sprintf(`e.EncodeString(%s)`, toBasic(f.typ, `string`, value))
+	case `bool`:
+		return fmt.Sprintf(`e.EncodeBool(%s)`, toBasic(f.typ, `bool`, value))
+	case `int`, `int8`, `int16`, `int32`, `int64`:
+		return fmt.Sprintf(`e.EncodeInt64(%s)`, toBasic(f.typ, `int64`, value))
+	case `uint`, `uint8`, `uint16`, `uint32`, `uint64`:
+		return fmt.Sprintf(`e.EncodeUint64(%s)`, toBasic(f.typ, `uint64`, value))
+	case `float32`, `float64`:
+		return fmt.Sprintf(`e.EncodeFloat64(%s)`, toBasic(f.typ, `float64`, value))
+	case `time`:
+		if f.timeFormat != `` {
+			return fmt.Sprintf(`e.EncodeTimeAs(%s, phpserialize.%s)`, value, f.timeFormat)
+		}
+		return fmt.Sprintf(`e.EncodeTime(%s)`, value)
+	case `duration`:
+		if f.seconds {
+			return fmt.Sprintf(`e.EncodeDurationSeconds(%s)`, value)
+		}
+		return fmt.Sprintf(`e.EncodeInt64(int64(%s))`, value)
+	case `custom`:
+		return fmt.Sprintf(`x.%s.EncodePHP(e)`, f.goName)
+	}
+	return fmt.Sprintf(`e.EncodeValue(reflect.ValueOf(&%s).Elem())`, value)
+}
+
+// toBasic returns value, of type typ, converted to the basic type to
+// when it differs.
+func toBasic(typ fieldType, to, value string) string {
+	if typ.expr == to {
+		return value
+	}
+	return to + `(` + value + `)`
+}
+
+func (g *generator) writeDecodeField(w *bytes.Buffer, f structField) {
+	field := `x.` + f.goName
+	if !f.typ.ptr {
+		g.writeDecodeValue(w, f, field)
+		return
+	}
+	fmt.Fprintf(w, "if code, _ := d.PeekCode(); code == 'N' {\n%s = nil\n", field)
+	fmt.Fprintf(w, "if err := d.DecodeNil(); err != nil {\nreturn err\n}\n")
+	fmt.Fprintf(w, "} else {\nif %s == nil {\n%s = new(%s)\n}\n", field, field, g.typeName(f.typ))
+	g.writeDecodeValue(w, f, `*`+field)
+	fmt.Fprintf(w, "}\n")
+}
+
+// writeDecodeValue writes the statements decoding into target, a field of
+// type f.typ or the value its pointer points to.
+func (g *generator) writeDecodeValue(w *bytes.Buffer, f structField, target string) {
+	wrap := fmt.Sprintf("if err != nil {\nreturn phpserialize.WithField(err, %s)\n}\n", strconv.Quote(f.goName))
+
+	var call, result string
+	switch f.typ.kind {
+	case `string`:
+		call, result = `d.DecodeString()`, `string`
+	case `bool`:
+		call, result = `d.DecodeBool()`, `bool`
+	case `int`:
+		call, result = `d.DecodeInt()`, `int`
+	case `int8`:
+		call, result = `d.DecodeInt8()`, `int8`
+	case `int16`:
+		call, result = `d.DecodeInt16()`, `int16`
+	case `int32`:
+		call, result = `d.DecodeInt32()`, `int32`
+	case `int64`:
+		call, result = `d.DecodeInt64()`, `int64`
+	case `uint`:
+		g.imports[`math/bits`] = ``
+		call, result = `d.DecodeUnsignedInt(bits.UintSize)`, `uint64`
+	case `uint8`, `uint16`, `uint32`, `uint64`:
+		call, result = fmt.Sprintf(`d.DecodeUnsignedInt(%s)`, f.typ.kind[4:]), `uint64`
+	case `float32`:
+		call, result = `d.DecodeFloat32()`, `float32`
+	case `float64`:
+		call, result = `d.DecodeFloat64()`, `float64`
+	case `time`:
+		call, result = `d.DecodeTime()`, f.typ.expr
+	case `duration`:
+		if f.seconds {
+			call, result = `d.DecodeDurationSeconds()`, f.typ.expr
+		} else {
+			call, result = `d.DecodeInt64()`, `int64`
+		}
+	case `custom`:
+		fmt.Fprintf(w, "if err := x.%s.DecodePHP(d); err != nil {\nreturn phpserialize.WithField(err, %s)\n}\n", f.goName, strconv.Quote(f.goName))
+		return
+	default:
+		fmt.Fprintf(w, "if err := d.DecodeValue(reflect.ValueOf(&%s).Elem()); err != nil {\nreturn phpserialize.WithField(err, %s)\n}\n", target, strconv.Quote(f.goName))
+		return
+	}
+
+	if result == f.typ.expr {
+		fmt.Fprintf(w, "%s, err = %s\n%s", target, call, wrap)
+		return
+	}
+	fmt.Fprintf(w, "v, err := %s\n%s%s = %s\n", call, wrap, target, g.convert(f.typ, result, `v`))
+}
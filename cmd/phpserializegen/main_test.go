@@ -0,0 +1,18 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGenerate checks that the generated code of the example package is up
+// to date. Its tests check that the code behaves like reflection.
+func TestGenerate(t *testing.T) {
+	out, err := generateFile(`internal/example/models.go`)
+	assert.Nil(t, err)
+	expected, err := ioutil.ReadFile(`internal/example/models` + outputSuffix)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(out))
+}
@@ -0,0 +1,124 @@
+package phpserialize
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var (
+	customEncoderType = reflect.TypeOf((*CustomEncoder)(nil)).Elem()
+	customDecoderType = reflect.TypeOf((*CustomDecoder)(nil)).Elem()
+	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType   = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// CustomEncoder is implemented by types that write themselves through the
+// Encoder primitives, such as the methods generated by phpserializegen.
+// EncodePHP must write exactly one value.
+type CustomEncoder interface {
+	EncodePHP(*Encoder) error
+}
+
+// CustomDecoder is implemented by types that read themselves through the
+// Decoder primitives. DecodePHP must read exactly one value.
+type CustomDecoder interface {
+	DecodePHP(*Decoder) error
+}
+
+// Marshaler is implemented by types that return their own serialized
+// form, which is written verbatim.
+type Marshaler interface {
+	MarshalPHP() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types that decode their own serialized
+// form. UnmarshalPHP receives a copy of the serialized value.
+type Unmarshaler interface {
+	UnmarshalPHP([]byte) error
+}
+
+func encodeCustomValue(e *Encoder, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return e.EncodeNil()
+	}
+	return v.Interface().(CustomEncoder).EncodePHP(e)
+}
+
+func encodeCustomValuePtr(e *Encoder, v reflect.Value) error {
+	return encodeCustomValue(e, addressable(v).Addr())
+}
+
+func marshalValue(e *Encoder, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return e.EncodeNil()
+	}
+	b, err := v.Interface().(Marshaler).MarshalPHP()
+	if err != nil {
+		return err
+	}
+	return e.write(b)
+}
+
+func marshalValuePtr(e *Encoder, v reflect.Value) error {
+	return marshalValue(e, addressable(v).Addr())
+}
+
+// addressable returns v, or an addressable copy of it, so that methods
+// with pointer receivers can be called on values that were not reached
+// through a pointer.
+func addressable(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr.Elem()
+}
+
+func decodeCustomValue(d *Decoder, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if d.hasNilCode() {
+			if !v.IsNil() {
+				v.Set(reflect.Zero(v.Type()))
+			}
+			return d.DecodeNil()
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+	}
+	return v.Interface().(CustomDecoder).DecodePHP(d)
+}
+
+func decodeCustomValueAddr(d *Decoder, v reflect.Value) error {
+	if !v.CanAddr() {
+		return fmt.Errorf("phpserialize: Decode(non-addressable %s)", v.Type())
+	}
+	return decodeCustomValue(d, v.Addr())
+}
+
+func unmarshalValue(d *Decoder, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if d.hasNilCode() {
+			if !v.IsNil() {
+				v.Set(reflect.Zero(v.Type()))
+			}
+			return d.DecodeNil()
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+	}
+	b, err := d.captureValue()
+	if err != nil {
+		return err
+	}
+	return v.Interface().(Unmarshaler).UnmarshalPHP(b)
+}
+
+func unmarshalValueAddr(d *Decoder, v reflect.Value) error {
+	if !v.CanAddr() {
+		return fmt.Errorf("phpserialize: Decode(non-addressable %s)", v.Type())
+	}
+	return unmarshalValue(d, v.Addr())
+}
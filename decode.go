@@ -9,63 +9,270 @@ import (
 	"math/bits"
 	"reflect"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 )
 
 type Decoder struct {
-	s     io.ByteScanner
-	flags uint32
+	s      io.ByteScanner
+	flags  uint32
+	loc    *time.Location
+	offset int64
+	depth  int
+
+	maxDepth     int
+	maxBytes     int64
+	maxStringLen int
+	maxElements  int
+
+	// allowedClasses is nil when every class is allowed.
+	allowedClasses map[string]struct{}
+
+	recording bool
+	rec       []byte
+
+	// buffered is set when s is a bufio.Reader created by the Decoder.
+	buffered bool
+	// scratch is reused by readUntil to collect numbers.
+	scratch []byte
+	// data holds the whole input of a Decoder created by NewDecoderBytes,
+	// which is read at offset instead of through s.
+	data []byte
 }
 
 const (
 	disallowUnknownFieldsFlag uint32 = 1 << iota
+	disallowedClassErrorFlag
+	unsafeStringsFlag
 )
 
 const (
-	// bytesAllocLimit = 1e6 // 1mb
+	bytesAllocLimit = 1e6 // 1mb
 	// sliceAllocLimit = 1e4
 	maxMapSize = 1e6
+
+	// maxNumberLen bounds the digits read for a single number or length,
+	// the longest float PHP writes is well below it.
+	maxNumberLen = 1024
 )
 
+// DefaultMaxDepth is the nesting depth a new Decoder allows, matching the
+// default of PHP's unserialize_max_depth.
+const DefaultMaxDepth = 4096
+
 var (
 	ErrUnsupported = errors.New(`unsupported target type`)
 )
 
+var (
+	boolType    = reflect.TypeOf(false)
+	intType     = reflect.TypeOf(int(0))
+	float32Type = reflect.TypeOf(float32(0))
+	float64Type = reflect.TypeOf(float64(0))
+
+	signedIntTypes = map[int]reflect.Type{
+		8:  reflect.TypeOf(int8(0)),
+		16: reflect.TypeOf(int16(0)),
+		32: reflect.TypeOf(int32(0)),
+		64: reflect.TypeOf(int64(0)),
+	}
+	unsignedIntTypes = map[int]reflect.Type{
+		8:  reflect.TypeOf(uint8(0)),
+		16: reflect.TypeOf(uint16(0)),
+		32: reflect.TypeOf(uint32(0)),
+		64: reflect.TypeOf(uint64(0)),
+	}
+)
+
 type bufReader interface {
 	// io.Reader
 	io.ByteScanner
 }
 
+// Unmarshal decodes the single serialized value in data into v. Any data
+// following the value is an error.
 func Unmarshal(data []byte, v interface{}) error {
-	d := NewDecoder(bytes.NewReader(data))
-	return d.Decode(v)
+	d := decPool.Get().(*Decoder)
+	d.ResetBytes(data)
+	err := d.decodeOnly(v)
+	d.ResetBytes(nil)
+	decPool.Put(d)
+	return err
 }
 
+// UnmarshalString is Unmarshal for data held in a string, which is decoded
+// without being copied.
 func UnmarshalString(data string, v interface{}) error {
-	d := NewDecoder(strings.NewReader(data))
-	return d.Decode(v)
+	return Unmarshal(stringToBytes(data), v)
+}
+
+// decPool holds the Decoders used by Unmarshal, which all keep the default
+// options.
+var decPool = sync.Pool{
+	New: func() interface{} {
+		return NewDecoderBytes(nil)
+	},
+}
+
+// decodeOnly decodes v and checks that no data follows it.
+func (d *Decoder) decodeOnly(v interface{}) error {
+	if err := d.Decode(v); err == io.EOF {
+		return syntaxErrorf(d.offset, `unexpected end of input`)
+	} else if err != nil {
+		return err
+	}
+	if d.More() {
+		return syntaxErrorf(d.offset, `unexpected data after top-level value`)
+	}
+	return nil
 }
 
 func NewDecoder(r io.Reader) *Decoder {
 	d := new(Decoder)
+	d.maxDepth = DefaultMaxDepth
 	d.resetReader(r)
 	return d
 }
 
+// NewDecoderBytes returns a new decoder that reads from data. Reading from
+// a slice is much faster than reading through an io.Reader, as strings are
+// copied in one go and can alias data with UseUnsafeStrings.
+func NewDecoderBytes(data []byte) *Decoder {
+	d := new(Decoder)
+	d.maxDepth = DefaultMaxDepth
+	d.resetBytes(data)
+	return d
+}
+
+// UseUnsafeStrings makes a Decoder created by NewDecoderBytes return
+// strings that share memory with its input instead of copies. The input
+// must not be modified while any decoded string is in use. It has no
+// effect on a Decoder reading from an io.Reader.
+func (d *Decoder) UseUnsafeStrings(on bool) {
+	if on {
+		d.flags |= unsafeStringsFlag
+	} else {
+		d.flags &= ^unsafeStringsFlag
+	}
+}
+
+// SetMaxDepth limits how deeply arrays and objects may nest. Zero disables
+// the limit. The default is DefaultMaxDepth.
+func (d *Decoder) SetMaxDepth(n int) {
+	d.maxDepth = n
+}
+
+// SetMaxBytes limits the total number of bytes the Decoder reads. Zero
+// disables the limit, which is the default.
+func (d *Decoder) SetMaxBytes(n int64) {
+	d.maxBytes = n
+}
+
+// SetMaxStringLength limits the length of a single string or class name.
+// Zero disables the limit, which is the default.
+func (d *Decoder) SetMaxStringLength(n int) {
+	d.maxStringLen = n
+}
+
+// SetMaxElements limits the number of elements of a single array or
+// object. Zero disables the limit, which is the default.
+func (d *Decoder) SetMaxElements(n int) {
+	d.maxElements = n
+}
+
+// SetTimeLocation sets the location used for decoded unix timestamps and
+// Y-m-d H:i:s strings, which carry no zone of their own. The default is UTC.
+func (d *Decoder) SetTimeLocation(loc *time.Location) {
+	d.loc = loc
+}
+
+// More reports whether there is another value to decode, for reading
+// values written back to back.
+func (d *Decoder) More() bool {
+	_, err := d.PeekCode()
+	return err == nil
+}
+
+// Buffered returns a reader of the data the Decoder has read from its
+// source but not yet decoded, which for a Decoder of a byte slice is the
+// rest of the slice. Readers that are already io.ByteScanners are read
+// from directly, so nothing is buffered for them.
+func (d *Decoder) Buffered() io.Reader {
+	if d.data != nil {
+		return bytes.NewReader(d.data[d.offset:])
+	}
+	if br, ok := d.s.(*bufio.Reader); ok && d.buffered {
+		b, _ := br.Peek(br.Buffered())
+		return bytes.NewReader(b)
+	}
+	return bytes.NewReader(nil)
+}
+
+// InputOffset returns the input offset of the next byte to be decoded.
+func (d *Decoder) InputOffset() int64 {
+	return d.offset
+}
+
+// Reset makes the Decoder read from r as if it was new, keeping the limits
+// and other options set on it.
+func (d *Decoder) Reset(r io.Reader) {
+	d.resetState()
+	d.resetReader(r)
+}
+
+// ResetBytes makes the Decoder read from data as if it was created by
+// NewDecoderBytes, keeping the limits and other options set on it.
+func (d *Decoder) ResetBytes(data []byte) {
+	d.resetState()
+	d.resetBytes(data)
+}
+
+func (d *Decoder) resetState() {
+	d.offset = 0
+	d.depth = 0
+	d.recording = false
+	d.rec = d.rec[:0]
+}
+
+func (d *Decoder) resetBytes(data []byte) {
+	if data == nil {
+		data = []byte{}
+	}
+	d.data = data
+	d.s = nil
+	d.buffered = false
+}
+
 func (d *Decoder) resetReader(r io.Reader) {
+	d.data = nil
 	if br, ok := r.(bufReader); ok {
 		//d.r = br
 		d.s = br
+		d.buffered = false
+	} else if br, ok := d.s.(*bufio.Reader); ok && d.buffered {
+		br.Reset(r)
 	} else {
 		br := bufio.NewReader(r)
 		//d.r = br
 		d.s = br
+		d.buffered = true
 	}
 }
 
-//nolint:gocyclo
+// Decode reads the next value and stores it in v. It returns io.EOF only
+// when the input ends before the value starts; input that ends within the
+// value is a SyntaxError.
 func (d *Decoder) Decode(v interface{}) error {
+	start := d.offset
+	err := d.decode(v)
+	if err == io.EOF && d.offset > start {
+		return syntaxErrorf(d.offset, `unexpected end of input`)
+	}
+	return err
+}
+
+//nolint:gocyclo
+func (d *Decoder) decode(v interface{}) error {
 	var err error
 	switch v := v.(type) {
 	case *string:
@@ -151,8 +358,6 @@ func (d *Decoder) Decode(v interface{}) error {
 		return d.decodeStringSlicePtr(v)
 	case *map[string]string:
 		return d.decodeMapStringStringPtr(v)
-	case *map[string]interface{}:
-		return ErrUnsupported // d.decodeMapStringInterfacePtr(v)
 	case *time.Duration:
 		if v != nil {
 			vv, err := d.DecodeInt64()
@@ -160,11 +365,10 @@ func (d *Decoder) Decode(v interface{}) error {
 			return err
 		}
 	case *time.Time:
-		return ErrUnsupported
-		/*if v != nil {
+		if v != nil {
 			*v, err = d.DecodeTime()
 			return err
-		}*/
+		}
 	}
 
 	vv := reflect.ValueOf(v)
@@ -188,10 +392,20 @@ func (d *Decoder) Decode(v interface{}) error {
 		}
 	}
 
-	return d.DecodeValue(vv)
+	err = d.DecodeValue(vv)
+	if e, ok := err.(*UnmarshalTypeError); ok && e.Field != `` && vv.Kind() == reflect.Struct && vv.Type().Name() != `` {
+		return withField(err, vv.Type().Name())
+	}
+	return err
 }
 
 func (d *Decoder) PeekCode() (byte, error) {
+	if d.data != nil {
+		if d.offset >= int64(len(d.data)) {
+			return 0, io.EOF
+		}
+		return d.data[d.offset], nil
+	}
 	c, err := d.s.ReadByte()
 	if err != nil {
 		return 0, err
@@ -199,6 +413,23 @@ func (d *Decoder) PeekCode() (byte, error) {
 	return c, d.s.UnreadByte()
 }
 
+// expectCode checks that the next value is of the kind that starts with
+// code. A value of another kind is reported as an UnmarshalTypeError for
+// typ, while anything that is not a value is left for the caller to report
+// as a syntax error.
+func (d *Decoder) expectCode(code byte, typ reflect.Type) error {
+	c, err := d.PeekCode()
+	if err != nil {
+		return err
+	}
+	switch c {
+	case code:
+	case 'N', 'b', 'i', 'd', 's', 'a', 'O', 'C', 'r', 'R':
+		return &UnmarshalTypeError{Value: phpValueName(c), Type: typ, Offset: d.offset}
+	}
+	return nil
+}
+
 func (d *Decoder) hasNilCode() bool {
 	code, err := d.PeekCode()
 	return err == nil && code == 'N'
@@ -211,23 +442,69 @@ func (d *Decoder) DecodeNil() error {
 func (d *Decoder) DecodeValue(v reflect.Value) error {
 	decode := getDecoder(v.Type())
 	if decode == nil {
-		return fmt.Errorf(`phpserialize: could not find decoder for: %s`, v.Type().String())
+		return d.unsupportedTypeError(v.Type())
 	}
 	return decode(d, v)
 }
 
+// unsupportedTypeError reports that the next value cannot be decoded into
+// typ at all.
+func (d *Decoder) unsupportedTypeError(typ reflect.Type) error {
+	code, err := d.PeekCode()
+	if err != nil {
+		return err
+	}
+	return &UnmarshalTypeError{Value: phpValueName(code), Type: typ, Offset: d.offset}
+}
+
+// DecodeInterface decodes the next value into the Go type closest to it:
+// nil, bool, int64, float64, string, []interface{} for arrays keyed 0..n-1
+// in order and map[interface{}]interface{} for any other array. Objects
+// decode into the type registered with RegisterClass, or into an
+// IncompleteObject.
+func (d *Decoder) DecodeInterface() (interface{}, error) {
+	offset := d.offset
+	code, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+
+	switch code {
+	case 'N':
+		return nil, d.DecodeNil()
+	case 'b':
+		return d.DecodeBool()
+	case 'i':
+		return d.DecodeInt64()
+	case 'd':
+		return d.DecodeFloat64()
+	case 's':
+		return d.DecodeString()
+	case 'a':
+		return d.decodeArrayInterface()
+	case 'O', 'C':
+		return d.decodeObjectInterface()
+	}
+
+	return nil, &UnmarshalTypeError{Value: phpValueName(code), Type: interfaceType, Offset: offset}
+}
+
 /**
   b:1;
   b:0;
 */
 func (d *Decoder) DecodeBool() (bool, error) {
+	if err := d.expectCode('b', boolType); err != nil {
+		return false, err
+	}
 	if err := d.skipExpected('b', ':'); err != nil {
 		return false, err
 	}
-	v, err := d.s.ReadByte()
+	v, err := d.readByte()
 	if err != nil {
 		return false, err
 	}
+	offset := d.offset - 1
 	if err := d.skipExpected(';'); err != nil {
 		return false, err
 	}
@@ -237,7 +514,7 @@ func (d *Decoder) DecodeBool() (bool, error) {
 	case '0':
 		return false, nil
 	default:
-		return false, errors.New(`phpserialize: Decode(invalid boolean value)`)
+		return false, syntaxErrorf(offset, `invalid boolean value`)
 	}
 }
 
@@ -249,7 +526,7 @@ func (d *Decoder) DecodeBool() (bool, error) {
 func (d *Decoder) DecodeInt() (int, error) {
 	v, err := d.DecodeSignedInt(bits.UintSize)
 	if err != nil {
-		return 0, err
+		return 0, withType(err, intType)
 	}
 	return int(v), nil
 }
@@ -283,6 +560,10 @@ func (d *Decoder) DecodeInt64() (int64, error) {
 }
 
 func (d *Decoder) DecodeSignedInt(bitSize int) (int64, error) {
+	offset := d.offset
+	if err := d.expectCode('i', signedIntTypes[bitSize]); err != nil {
+		return 0, err
+	}
 	if err := d.skipExpected('i', ':'); err != nil {
 		return 0, err
 	}
@@ -292,10 +573,22 @@ func (d *Decoder) DecodeSignedInt(bitSize int) (int64, error) {
 		return 0, err
 	}
 
-	return strconv.ParseInt(string(acc), 10, bitSize)
+	if n, ok := parseDecimal(acc); ok && fitsInt(n, bitSize) {
+		return n, nil
+	}
+	// Let strconv tell a malformed number from one that is out of range.
+	n, err := strconv.ParseInt(string(acc), 10, bitSize)
+	if err != nil {
+		return 0, numberError(err, `integer`, acc, offset, signedIntTypes[bitSize])
+	}
+	return n, nil
 }
 
 func (d *Decoder) DecodeUnsignedInt(bitSize int) (uint64, error) {
+	offset := d.offset
+	if err := d.expectCode('i', unsignedIntTypes[bitSize]); err != nil {
+		return 0, err
+	}
 	if err := d.skipExpected('i', ':'); err != nil {
 		return 0, err
 	}
@@ -305,7 +598,68 @@ func (d *Decoder) DecodeUnsignedInt(bitSize int) (uint64, error) {
 		return 0, err
 	}
 
-	return strconv.ParseUint(string(acc), 10, bitSize)
+	if n, ok := parseDecimal(acc); ok && n >= 0 && (bitSize == 64 || n < 1<<uint(bitSize)) {
+		return uint64(n), nil
+	}
+	n, err := strconv.ParseUint(string(acc), 10, bitSize)
+	if err != nil {
+		// Negative integers are well formed, they just do not fit.
+		if _, signedErr := strconv.ParseInt(string(acc), 10, 64); signedErr == nil {
+			return 0, &UnmarshalTypeError{Value: `integer ` + string(acc), Type: unsignedIntTypes[bitSize], Offset: offset}
+		}
+		return 0, numberError(err, `integer`, acc, offset, unsignedIntTypes[bitSize])
+	}
+	return n, nil
+}
+
+// parseDecimal parses an optionally signed decimal integer without
+// allocating. It reports false for anything that is malformed or does not
+// fit in an int64.
+func parseDecimal(b []byte) (int64, bool) {
+	neg := false
+	if len(b) > 0 && (b[0] == '-' || b[0] == '+') {
+		neg = b[0] == '-'
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 19 {
+		return 0, false
+	}
+	var n uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + uint64(c-'0')
+	}
+	if neg {
+		if n > 1<<63 {
+			return 0, false
+		}
+		return -int64(n), true
+	}
+	if n > 1<<63-1 {
+		return 0, false
+	}
+	return int64(n), true
+}
+
+// fitsInt reports whether n fits in a signed integer of bitSize bits.
+func fitsInt(n int64, bitSize int) bool {
+	if bitSize == 64 {
+		return true
+	}
+	limit := int64(1) << uint(bitSize-1)
+	return n >= -limit && n < limit
+}
+
+// numberError converts a strconv error for the number acc starting at
+// offset into a SyntaxError or, when it is out of range for typ, an
+// UnmarshalTypeError.
+func numberError(err error, kind string, acc []byte, offset int64, typ reflect.Type) error {
+	if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange {
+		return &UnmarshalTypeError{Value: kind + ` ` + string(acc), Type: typ, Offset: offset}
+	}
+	return syntaxErrorf(offset, `invalid %s %q`, kind, acc)
 }
 
 /**
@@ -327,6 +681,14 @@ func (d *Decoder) DecodeFloat32() (float32, error) {
 }
 
 func (d *Decoder) DecodeFloat(bitSize int) (float64, error) {
+	offset := d.offset
+	typ := float64Type
+	if bitSize == 32 {
+		typ = float32Type
+	}
+	if err := d.expectCode('d', typ); err != nil {
+		return 0, err
+	}
 	if err := d.skipExpected('d', ':'); err != nil {
 		return 0, err
 	}
@@ -336,83 +698,387 @@ func (d *Decoder) DecodeFloat(bitSize int) (float64, error) {
 		return 0, err
 	}
 
-	return strconv.ParseFloat(string(acc), bitSize)
+	// ParseFloat also accepts hexadecimal floats, underscores and spelled
+	// out infinities, which PHP does not.
+	if !validFloat(acc) {
+		return 0, syntaxErrorf(offset, `invalid float %q`, acc)
+	}
+	// The string shares memory with acc, which is fine as err is not kept.
+	f, err := strconv.ParseFloat(bytesToString(acc), bitSize)
+	if err != nil {
+		return 0, numberError(err, `float`, acc, offset, typ)
+	}
+	return f, nil
 }
 
 func (d *Decoder) DecodeString() (string, error) {
+	if err := d.expectCode('s', stringType); err != nil {
+		return ``, err
+	}
 	if err := d.skipExpected('s', ':'); err != nil {
 		return ``, err
 	}
-	strLen, err := d.readUntilLen()
+	acc, err := d.readQuoted()
 	if err != nil {
 		return ``, err
 	}
-	if err := d.skipExpected('"'); err != nil {
+	if err := d.skipExpected(';'); err != nil {
 		return ``, err
 	}
-	acc := make([]byte, strLen)
-	for x := 0; x < strLen; x++ {
-		b, err := d.s.ReadByte()
+
+	if d.flags&unsafeStringsFlag != 0 && d.data != nil {
+		return bytesToString(acc), nil
+	}
+	return string(acc), nil
+}
+
+// readQuoted reads a length prefixed quoted byte sequence such as 5:"Hello"
+// which is shared by strings and class names.
+func (d *Decoder) readQuoted() ([]byte, error) {
+	offset := d.offset
+	strLen, err := d.readUntilLen()
+	if err != nil {
+		return nil, err
+	}
+	if d.maxStringLen > 0 && strLen > d.maxStringLen {
+		return nil, &LimitError{Limit: `string length`, Max: int64(d.maxStringLen), Offset: offset}
+	}
+	if err := d.skipExpected('"'); err != nil {
+		return nil, err
+	}
+	acc, err := d.readSlice(strLen)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.skipExpected('"'); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// readSlice reads n bytes, which alias the input of a Decoder created by
+// NewDecoderBytes and must be copied to be kept.
+func (d *Decoder) readSlice(n int) ([]byte, error) {
+	if d.data == nil {
+		return d.readBytes(n)
+	}
+	end := d.offset + int64(n)
+	if d.maxBytes > 0 && end > d.maxBytes {
+		return nil, &LimitError{Limit: `bytes`, Max: d.maxBytes, Offset: d.maxBytes}
+	}
+	if end > int64(len(d.data)) {
+		d.offset = int64(len(d.data))
+		return nil, io.EOF
+	}
+	b := d.data[d.offset:end:end]
+	d.advance(b)
+	return b, nil
+}
+
+// advance consumes b, which starts at offset in the input of a Decoder
+// created by NewDecoderBytes.
+func (d *Decoder) advance(b []byte) {
+	d.offset += int64(len(b))
+	if d.recording {
+		d.rec = append(d.rec, b...)
+	}
+}
+
+func (d *Decoder) readBytes(n int) ([]byte, error) {
+	if d.data != nil {
+		b, err := d.readSlice(n)
 		if err != nil {
-			return ``, err
+			return nil, err
 		}
-		acc[x] = b
+		return append([]byte(nil), b...), nil
 	}
-	if err := d.skipExpected('"', ';'); err != nil {
-		return ``, err
+	// The length is untrusted, so the buffer only grows as bytes arrive.
+	acc := make([]byte, 0, min(n, bytesAllocLimit))
+	for x := 0; x < n; x++ {
+		b, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		acc = append(acc, b)
 	}
-
-	return string(acc), nil
+	return acc, nil
 }
 
+// readUntil reads up to and including v and returns the bytes before it,
+// which are only valid until the next read.
 func (d *Decoder) readUntil(v byte) ([]byte, error) {
-	var acc []byte
+	if d.data != nil {
+		return d.readSliceUntil(v)
+	}
+	acc := d.scratch[:0]
 	for {
-		b, err := d.s.ReadByte()
+		b, err := d.readByte()
 		if err != nil {
 			return nil, err
 		}
 		if b == v {
 			break
 		}
+		if len(acc) == maxNumberLen {
+			return nil, syntaxErrorf(d.offset-1, `expected byte '%c' within %d bytes`, v, maxNumberLen)
+		}
 		acc = append(acc, b)
 	}
+	d.scratch = acc
 	return acc, nil
 }
 
+// readSliceUntil is readUntil for a Decoder created by NewDecoderBytes. The
+// returned bytes alias the input.
+func (d *Decoder) readSliceUntil(v byte) ([]byte, error) {
+	window := d.data[d.offset:]
+	if len(window) > maxNumberLen+1 {
+		window = window[:maxNumberLen+1]
+	}
+	i := bytes.IndexByte(window, v)
+	if i < 0 {
+		if d.maxBytes > 0 && d.offset+int64(len(window)) > d.maxBytes {
+			return nil, &LimitError{Limit: `bytes`, Max: d.maxBytes, Offset: d.maxBytes}
+		}
+		if len(window) > maxNumberLen {
+			return nil, syntaxErrorf(d.offset+maxNumberLen, `expected byte '%c' within %d bytes`, v, maxNumberLen)
+		}
+		d.offset += int64(len(window))
+		return nil, io.EOF
+	}
+	if d.maxBytes > 0 && d.offset+int64(i) >= d.maxBytes {
+		return nil, &LimitError{Limit: `bytes`, Max: d.maxBytes, Offset: d.maxBytes}
+	}
+	d.advance(window[:i+1])
+	return window[:i:i], nil
+}
+
 func (d *Decoder) readUntilLen() (int, error) {
+	offset := d.offset
 	acc, err := d.readUntil(':')
 	if err != nil {
 		return 0, err
 	}
-	return strconv.Atoi(string(acc))
+	// Unlike integers, lengths and counts are written without a sign.
+	n, ok := parseDecimal(acc)
+	if !ok || acc[0] < '0' || acc[0] > '9' || n > int64(maxInt) {
+		return 0, syntaxErrorf(offset, `invalid length %q`, acc)
+	}
+	return int(n), nil
 }
 
 func (d *Decoder) skipExpected(expected ...byte) error {
 	for _, e := range expected {
-		c, err := d.s.ReadByte()
+		c, err := d.readByte()
 		if err != nil {
 			return err
 		}
 		if c != e {
-			return fmt.Errorf(`phpserialize: Decode(expected byte '%c' found '%c')`, e, c)
+			return syntaxErrorf(d.offset-1, `expected byte '%c' found '%c'`, e, c)
 		}
 	}
 	return nil
 }
 
-func (d *Decoder) decodeArrayLen() (int, error) {
+// readCount reads the element count of an array or object.
+func (d *Decoder) readCount() (int, error) {
+	offset := d.offset
+	n, err := d.readUntilLen()
+	if err != nil {
+		return 0, err
+	}
+	if d.maxElements > 0 && n > d.maxElements {
+		return 0, &LimitError{Limit: `elements`, Max: int64(d.maxElements), Offset: offset}
+	}
+	return n, nil
+}
+
+// enter records that the array or object starting at offset has been
+// opened.
+func (d *Decoder) enter(offset int64) error {
+	d.depth++
+	if d.maxDepth > 0 && d.depth > d.maxDepth {
+		return &LimitError{Limit: `depth`, Max: int64(d.maxDepth), Offset: offset}
+	}
+	return nil
+}
+
+// DecodeArrayEnd reads the closing brace of an array or object.
+func (d *Decoder) DecodeArrayEnd() error {
+	if err := d.skipExpected('}'); err != nil {
+		return err
+	}
+	d.depth--
+	return nil
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	if d.maxBytes > 0 && d.offset >= d.maxBytes {
+		return 0, &LimitError{Limit: `bytes`, Max: d.maxBytes, Offset: d.offset}
+	}
+	var c byte
+	if d.data != nil {
+		if d.offset >= int64(len(d.data)) {
+			return 0, io.EOF
+		}
+		c = d.data[d.offset]
+	} else {
+		var err error
+		if c, err = d.s.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	d.offset++
+	if d.recording {
+		d.rec = append(d.rec, c)
+	}
+	return c, nil
+}
+
+// DecodeArrayLen reads the a:n:{ header of an array and returns n. The
+// elements are to be followed by DecodeArrayEnd.
+func (d *Decoder) DecodeArrayLen() (int, error) {
+	offset := d.offset
 	if err := d.skipExpected('a', ':'); err != nil {
 		return 0, err
 	}
-	n, err := d.readUntilLen()
+	n, err := d.readCount()
 	if err != nil {
 		return 0, err
 	}
 	if err := d.skipExpected('{'); err != nil {
 		return 0, err
 	}
-	return n, nil
+	return n, d.enter(offset)
+}
+
+// DecodeObjectLen reads the O: header of an object that is about to be
+// decoded into a Go type and returns its class and number of properties.
+// The class must be allowed. The properties are to be followed by
+// DecodeArrayEnd.
+func (d *Decoder) DecodeObjectLen() (string, int, error) {
+	offset := d.offset
+	class, n, err := d.readObjectHeader()
+	if err != nil {
+		return ``, 0, err
+	}
+	return class, n, d.checkClass(class, offset, false)
+}
+
+/**
+  O:8:"DateTime":3:{
+*/
+func (d *Decoder) readObjectHeader() (string, int, error) {
+	offset := d.offset
+	if err := d.skipExpected('O', ':'); err != nil {
+		return ``, 0, err
+	}
+	class, err := d.readQuoted()
+	if err != nil {
+		return ``, 0, err
+	}
+	if err := d.skipExpected(':'); err != nil {
+		return ``, 0, err
+	}
+	n, err := d.readCount()
+	if err != nil {
+		return ``, 0, err
+	}
+	if err := d.skipExpected('{'); err != nil {
+		return ``, 0, err
+	}
+	return string(class), n, d.enter(offset)
+}
+
+// Skip discards the next value, including the elements of arrays and
+// objects, without decoding it into a Go type. Objects nested at any depth
+// are still checked against SetAllowedClasses when ErrorOnDisallowedClass
+// is set.
+func (d *Decoder) Skip() error {
+	offset := d.offset
+	code, err := d.PeekCode()
+	if err != nil {
+		return err
+	}
+
+	switch code {
+	case 'N':
+		return d.DecodeNil()
+	case 'b', 'i', 'd', 'r', 'R':
+		if err := d.skipExpected(code, ':'); err != nil {
+			return err
+		}
+		_, err := d.readUntil(';')
+		return err
+	case 's':
+		_, err := d.DecodeString()
+		return err
+	case 'a':
+		n, err := d.DecodeArrayLen()
+		if err != nil {
+			return err
+		}
+		return d.skipElements(n)
+	case 'O':
+		class, n, err := d.readObjectHeader()
+		if err != nil {
+			return err
+		}
+		if err := d.checkClass(class, offset, true); err != nil {
+			return err
+		}
+		return d.skipElements(n)
+	case 'C':
+		class, n, err := d.readCustomHeader()
+		if err != nil {
+			return err
+		}
+		if err := d.checkClass(class, offset, true); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if _, err := d.readByte(); err != nil {
+				return err
+			}
+		}
+		return d.skipExpected('}')
+	}
+
+	return syntaxErrorf(d.offset, `unexpected code '%c'`, code)
+}
+
+// skipElements skips n key value pairs followed by the closing brace.
+func (d *Decoder) skipElements(n int) error {
+	for i := 0; i < 2*n; i++ {
+		if err := d.Skip(); err != nil {
+			return err
+		}
+	}
+	return d.DecodeArrayEnd()
+}
+
+/**
+  C:11:"ArrayObject":21:{
+*/
+func (d *Decoder) readCustomHeader() (string, int, error) {
+	if err := d.skipExpected('C', ':'); err != nil {
+		return ``, 0, err
+	}
+	class, err := d.readQuoted()
+	if err != nil {
+		return ``, 0, err
+	}
+	if err := d.skipExpected(':'); err != nil {
+		return ``, 0, err
+	}
+	n, err := d.readUntilLen()
+	if err != nil {
+		return ``, 0, err
+	}
+	if err := d.skipExpected('{'); err != nil {
+		return ``, 0, err
+	}
+	return string(class), n, nil
 }
 
 func min(a, b int) int { //nolint:unparam
@@ -1,6 +1,7 @@
 package phpserialize
 
 import (
+	"fmt"
 	"reflect"
 )
 
@@ -10,7 +11,10 @@ var (
 )
 
 func decodeMapValue(d *Decoder, v reflect.Value) error {
-	n, err := d.decodeArrayLen()
+	if err := d.expectCode('a', v.Type()); err != nil {
+		return err
+	}
+	n, err := d.DecodeArrayLen()
 	if err != nil {
 		return err
 	}
@@ -24,15 +28,12 @@ func decodeMapValue(d *Decoder, v reflect.Value) error {
 	if v.IsNil() {
 		v.Set(reflect.MakeMap(typ))
 	}
-	if n == 0 {
-		return nil
-	}
 
 	if err := d.decodeTypedMapValue(v, n); err != nil {
 		return err
 	}
 
-	return d.skipExpected('}')
+	return d.DecodeArrayEnd()
 }
 
 func (d *Decoder) decodeTypedMapValue(v reflect.Value, n int) error {
@@ -48,7 +49,7 @@ func (d *Decoder) decodeTypedMapValue(v reflect.Value, n int) error {
 
 		mv := reflect.New(valueType).Elem()
 		if err := d.DecodeValue(mv); err != nil {
-			return err
+			return withField(err, fmt.Sprintf(`[%v]`, mk.Interface()))
 		}
 
 		v.SetMapIndex(mk, mv)
@@ -58,7 +59,10 @@ func (d *Decoder) decodeTypedMapValue(v reflect.Value, n int) error {
 }
 
 func (d *Decoder) decodeMapStringStringPtr(ptr *map[string]string) error {
-	size, err := d.decodeArrayLen()
+	if err := d.expectCode('a', mapStringStringType); err != nil {
+		return err
+	}
+	size, err := d.DecodeArrayLen()
 	if err != nil {
 		return err
 	}
@@ -80,15 +84,74 @@ func (d *Decoder) decodeMapStringStringPtr(ptr *map[string]string) error {
 		}
 		mv, err := d.DecodeString()
 		if err != nil {
-			return err
+			return withField(err, `[`+mk+`]`)
 		}
 		m[mk] = mv
 	}
 
-	return d.skipExpected('}')
+	return d.DecodeArrayEnd()
 }
 
 func decodeMapStringStringValue(d *Decoder, v reflect.Value) error {
 	mptr := v.Addr().Convert(mapStringStringPtrType).Interface().(*map[string]string)
 	return d.decodeMapStringStringPtr(mptr)
 }
+
+// decodeArrayInterface decodes an array as []interface{} while its keys
+// run 0..n-1 in order, switching to map[interface{}]interface{} at the
+// first key that does not.
+func (d *Decoder) decodeArrayInterface() (interface{}, error) {
+	n, err := d.DecodeArrayLen()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]interface{}, 0, min(n, sliceAllocLimit))
+	var m map[interface{}]interface{}
+	for i := 0; i < n; i++ {
+		key, err := d.decodeArrayKey()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.DecodeInterface()
+		if err != nil {
+			return nil, withField(err, fmt.Sprintf(`[%v]`, key))
+		}
+
+		if m == nil {
+			if key == int64(i) {
+				list = append(list, value)
+				continue
+			}
+			m = make(map[interface{}]interface{}, min(n, maxMapSize))
+			for j, v := range list {
+				m[int64(j)] = v
+			}
+		}
+		m[key] = value
+	}
+
+	if err := d.DecodeArrayEnd(); err != nil {
+		return nil, err
+	}
+	if m != nil {
+		return m, nil
+	}
+	return list, nil
+}
+
+// decodeArrayKey decodes an array key, which PHP stores as either an int64
+// or a string.
+func (d *Decoder) decodeArrayKey() (interface{}, error) {
+	code, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	switch code {
+	case 'i':
+		return d.DecodeInt64()
+	case 's':
+		return d.DecodeString()
+	}
+	return nil, syntaxErrorf(d.offset, `invalid array key '%c'`, code)
+}
@@ -0,0 +1,274 @@
+package phpserialize
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var incompleteObjectType = reflect.TypeOf((*IncompleteObject)(nil)).Elem()
+
+// IncompleteObject holds a serialized object that was not decoded into a
+// Go type, the counterpart of PHP's __PHP_Incomplete_Class.
+type IncompleteObject struct {
+	Class string
+	// Properties holds the properties of an O: object in their serialized
+	// order.
+	Properties []ObjectProperty
+	// Custom is set for C: objects written by Serializable::serialize,
+	// whose payload is kept in Data instead of Properties.
+	Custom bool
+	Data   []byte
+}
+
+// ObjectProperty is a single property of an IncompleteObject.
+type ObjectProperty struct {
+	// Name is the property name as serialized, including the visibility
+	// mangling PHP applies to protected and private properties.
+	Name string
+	// Value is the serialized property value.
+	Value []byte
+
+	intKey bool
+}
+
+// Property returns the serialized value of the property called name,
+// matching names regardless of their visibility mangling.
+func (o IncompleteObject) Property(name string) ([]byte, bool) {
+	for _, p := range o.Properties {
+		if unmangledName(p.Name) == name {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	classTypes  sync.Map // lower case class name -> reflect.Type
+	typeClasses sync.Map // struct type -> class name
+)
+
+// RegisterClass registers the struct type of v, or the pointer to struct
+// type, for the PHP class name. Objects of that class decode into it when
+// the target is an interface{}, and the struct type encodes as an object
+// of that class instead of an array. Objects of classes that are not
+// registered decode into an IncompleteObject.
+func RegisterClass(name string, v interface{}) {
+	typ := reflect.TypeOf(v)
+	if typ == nil || indirectType(typ).Kind() != reflect.Struct {
+		panic(`phpserialize: RegisterClass(` + name + `) requires a struct or pointer to struct`)
+	}
+	classTypes.Store(strings.ToLower(name), typ)
+	typeClasses.Store(indirectType(typ), name)
+}
+
+func registeredType(class string) (reflect.Type, bool) {
+	if v, ok := classTypes.Load(strings.ToLower(class)); ok {
+		return v.(reflect.Type), true
+	}
+	return nil, false
+}
+
+func registeredClass(typ reflect.Type) (string, bool) {
+	if v, ok := typeClasses.Load(typ); ok {
+		return v.(string), true
+	}
+	return ``, false
+}
+
+// SetAllowedClasses restricts which classes may be decoded, like the
+// allowed_classes option of PHP's unserialize. Class names are compared
+// case-insensitively. Calling it without names allows no classes at all.
+//
+// Objects of other classes still decode into an IncompleteObject, unless
+// ErrorOnDisallowedClass is set, and fail to decode into any other type.
+func (d *Decoder) SetAllowedClasses(names ...string) {
+	d.allowedClasses = make(map[string]struct{}, len(names))
+	for _, name := range names {
+		d.allowedClasses[strings.ToLower(name)] = struct{}{}
+	}
+}
+
+// AllowAllClasses lifts any restriction set by SetAllowedClasses, which is
+// the default.
+func (d *Decoder) AllowAllClasses() {
+	d.allowedClasses = nil
+}
+
+// ErrorOnDisallowedClass makes objects of classes not allowed by
+// SetAllowedClasses fail with a ClassNotAllowedError instead of decoding
+// into an IncompleteObject placeholder.
+func (d *Decoder) ErrorOnDisallowedClass(on bool) {
+	if on {
+		d.flags |= disallowedClassErrorFlag
+	} else {
+		d.flags &= ^disallowedClassErrorFlag
+	}
+}
+
+func (d *Decoder) classAllowed(class string) bool {
+	if d.allowedClasses == nil {
+		return true
+	}
+	_, ok := d.allowedClasses[strings.ToLower(class)]
+	return ok
+}
+
+// checkClass reports whether an object of class starting at offset may be
+// decoded. A placeholder may stand in for disallowed classes unless the
+// Decoder is set to error on them.
+func (d *Decoder) checkClass(class string, offset int64, placeholder bool) error {
+	if d.classAllowed(class) || (placeholder && d.flags&disallowedClassErrorFlag == 0) {
+		return nil
+	}
+	return &ClassNotAllowedError{Class: class, Offset: offset}
+}
+
+func decodeIncompleteObjectValue(d *Decoder, v reflect.Value) error {
+	obj, err := d.decodeIncompleteObject()
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(obj))
+	return nil
+}
+
+/**
+  O:3:"Foo":1:{s:6:"\0*\0bar";i:1;}
+  C:11:"ArrayObject":21:{x:i:0;a:0:{};m:a:0:{}}
+*/
+func (d *Decoder) decodeIncompleteObject() (IncompleteObject, error) {
+	var obj IncompleteObject
+
+	offset := d.offset
+	code, err := d.PeekCode()
+	if err != nil {
+		return obj, err
+	}
+
+	switch code {
+	case 'O':
+		class, n, err := d.readObjectHeader()
+		if err != nil {
+			return obj, err
+		}
+		return d.decodeObjectProperties(class, n, offset)
+	case 'C':
+		class, n, err := d.readCustomHeader()
+		if err != nil {
+			return obj, err
+		}
+		if err := d.checkClass(class, offset, true); err != nil {
+			return obj, err
+		}
+		obj.Class = class
+		obj.Custom = true
+		if obj.Data, err = d.readBytes(n); err != nil {
+			return obj, err
+		}
+		return obj, d.skipExpected('}')
+	}
+
+	return obj, &UnmarshalTypeError{Value: phpValueName(code), Type: incompleteObjectType, Offset: offset}
+}
+
+// decodeObjectProperties decodes the properties of an object of class,
+// whose header starting at offset has been read, into an IncompleteObject.
+func (d *Decoder) decodeObjectProperties(class string, n int, offset int64) (IncompleteObject, error) {
+	obj := IncompleteObject{Class: class}
+	if err := d.checkClass(class, offset, true); err != nil {
+		return obj, err
+	}
+
+	var err error
+	obj.Properties = make([]ObjectProperty, 0, min(n, sliceAllocLimit))
+	for i := 0; i < n; i++ {
+		var p ObjectProperty
+		if p.Name, p.intKey, err = d.decodePropertyName(); err != nil {
+			return obj, err
+		}
+		if p.Value, err = d.captureValue(); err != nil {
+			return obj, err
+		}
+		obj.Properties = append(obj.Properties, p)
+	}
+	return obj, d.DecodeArrayEnd()
+}
+
+// decodeObjectInterface decodes an object into a new value of the Go type
+// registered for its class, or into an IncompleteObject when the class is
+// not registered or not allowed.
+func (d *Decoder) decodeObjectInterface() (interface{}, error) {
+	offset := d.offset
+	code, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	if code != 'O' {
+		return d.decodeIncompleteObject()
+	}
+
+	class, n, err := d.readObjectHeader()
+	if err != nil {
+		return nil, err
+	}
+	typ, ok := registeredType(class)
+	if !ok || !d.classAllowed(class) {
+		return d.decodeObjectProperties(class, n, offset)
+	}
+
+	v := reflect.New(indirectType(typ))
+	if err := decodeStructFields(d, v.Elem(), n); err != nil {
+		return nil, err
+	}
+	if typ.Kind() == reflect.Ptr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
+
+// decodePropertyName decodes a property name, which is normally a string
+// but is an integer for objects cast from arrays by older PHP versions.
+func (d *Decoder) decodePropertyName() (string, bool, error) {
+	code, err := d.PeekCode()
+	if err != nil {
+		return ``, false, err
+	}
+	if code == 'i' {
+		n, err := d.DecodeInt64()
+		return strconv.FormatInt(n, 10), true, err
+	}
+	name, err := d.DecodeString()
+	return name, false, err
+}
+
+// captureValue skips the next value and returns its serialized bytes.
+func (d *Decoder) captureValue() ([]byte, error) {
+	if d.data != nil {
+		start := d.offset
+		if err := d.Skip(); err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), d.data[start:d.offset]...), nil
+	}
+	d.rec = d.rec[:0]
+	d.recording = true
+	err := d.Skip()
+	d.recording = false
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), d.rec...), nil
+}
+
+// unmangledName strips the visibility prefix PHP adds to the names of
+// protected ("\0*\0name") and private ("\0Class\0name") properties.
+func unmangledName(name string) string {
+	if len(name) > 0 && name[0] == 0 {
+		if i := strings.IndexByte(name[1:], 0); i >= 0 {
+			return name[i+2:]
+		}
+	}
+	return name
+}
@@ -3,6 +3,7 @@ package phpserialize
 import (
 	"fmt"
 	"reflect"
+	"strconv"
 )
 
 var sliceStringPtrType = reflect.TypeOf((*[]string)(nil))
@@ -12,7 +13,10 @@ const (
 )
 
 func decodeSliceValue(d *Decoder, v reflect.Value) error {
-	n, err := d.decodeArrayLen()
+	if err := d.expectCode('a', v.Type()); err != nil {
+		return err
+	}
+	n, err := d.DecodeArrayLen()
 	if err != nil {
 		return err
 	}
@@ -23,7 +27,7 @@ func decodeSliceValue(d *Decoder, v reflect.Value) error {
 	}
 	if n == 0 && v.IsNil() {
 		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
-		return nil
+		return d.DecodeArrayEnd()
 	}
 
 	if v.Cap() >= n {
@@ -37,19 +41,36 @@ func decodeSliceValue(d *Decoder, v reflect.Value) error {
 			v.Set(growSliceValue(v, n))
 		}
 		elem := v.Index(i)
-		decodedIndex, err := d.DecodeInt()
-		if err != nil {
+		if err := d.decodeListKey(i, v.Type()); err != nil {
 			return err
 		}
-		if decodedIndex != i {
-			return fmt.Errorf(`phpserialize: Decode(expected offset '%d' found '%d')`, i, decodedIndex)
-		}
 		if err := d.DecodeValue(elem); err != nil {
-			return err
+			return withField(err, `[`+strconv.Itoa(i)+`]`)
 		}
 	}
 
-	return d.skipExpected('}')
+	return d.DecodeArrayEnd()
+}
+
+// decodeListKey decodes the key of the i-th element of an array decoded
+// into a slice of type typ. Only arrays keyed 0..n-1 in order fit a slice.
+func (d *Decoder) decodeListKey(i int, typ reflect.Type) error {
+	offset := d.offset
+	code, err := d.PeekCode()
+	if err != nil {
+		return err
+	}
+	if code != 'i' {
+		return &UnmarshalTypeError{Value: `array with ` + phpValueName(code) + ` key`, Type: typ, Offset: offset}
+	}
+	key, err := d.DecodeInt()
+	if err != nil {
+		return err
+	}
+	if key != i {
+		return &UnmarshalTypeError{Value: fmt.Sprintf(`array key %d at position %d`, key, i), Type: typ, Offset: offset}
+	}
+	return nil
 }
 
 func growSliceValue(v reflect.Value, n int) reflect.Value {
@@ -67,7 +88,10 @@ func decodeStringSliceValue(d *Decoder, v reflect.Value) error {
 }
 
 func (d *Decoder) decodeStringSlicePtr(ptr *[]string) error {
-	n, err := d.decodeArrayLen()
+	if err := d.expectCode('a', sliceStringPtrType.Elem()); err != nil {
+		return err
+	}
+	n, err := d.DecodeArrayLen()
 	if err != nil {
 		return err
 	}
@@ -77,22 +101,18 @@ func (d *Decoder) decodeStringSlicePtr(ptr *[]string) error {
 
 	ss := makeStrings(*ptr, n)
 	for i := 0; i < n; i++ {
-		decodedIndex, err := d.DecodeInt()
-		if err != nil {
+		if err := d.decodeListKey(i, sliceStringPtrType.Elem()); err != nil {
 			return err
 		}
-		if decodedIndex != i {
-			return fmt.Errorf(`phpserialize: Decode(expected offset '%d' found '%d')`, i, decodedIndex)
-		}
 		s, err := d.DecodeString()
 		if err != nil {
-			return err
+			return withField(err, `[`+strconv.Itoa(i)+`]`)
 		}
 		ss = append(ss, s)
 	}
 	*ptr = ss
 
-	return d.skipExpected('}')
+	return d.DecodeArrayEnd()
 }
 
 func makeStrings(s []string, n int) []string {
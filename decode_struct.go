@@ -6,14 +6,59 @@ import (
 )
 
 func decodeStructValue(d *Decoder, v reflect.Value) error {
-	arrayLen, err := d.decodeArrayLen()
+	// Structs decode from objects of any class as well as from arrays.
+	if code, err := d.PeekCode(); err == nil && code != 'O' {
+		if err := d.expectCode('a', v.Type()); err != nil {
+			return err
+		}
+	}
+	arrayLen, err := d.DecodeStructLen()
 	if err != nil {
 		return err
 	}
+	return decodeStructFields(d, v, arrayLen)
+}
+
+// DecodeStructLen reads the header of an array or object that is decoded
+// into a struct and returns its number of properties. Each property is
+// read with DecodePropertyName followed by its value, and the properties
+// are followed by DecodeArrayEnd.
+func (d *Decoder) DecodeStructLen() (int, error) {
+	code, err := d.PeekCode()
+	if err != nil {
+		return 0, err
+	}
+
+	// Objects decode like arrays of their properties.
+	if code == 'O' {
+		_, n, err := d.DecodeObjectLen()
+		return n, err
+	}
+	return d.DecodeArrayLen()
+}
+
+// DecodePropertyName decodes the name of a struct property, without the
+// visibility mangling of protected and private properties.
+func (d *Decoder) DecodePropertyName() (string, error) {
+	name, err := d.DecodeString()
+	return unmangledName(name), err
+}
+
+// SkipProperty skips the value of the property called name, which has no
+// struct field, or fails when unknown fields are disallowed.
+func (d *Decoder) SkipProperty(name string) error {
+	if d.flags&disallowUnknownFieldsFlag != 0 {
+		return fmt.Errorf("phpserialize: unknown field %q", name)
+	}
+	return d.Skip()
+}
 
+// decodeStructFields decodes arrayLen properties into the fields of v
+// followed by the closing brace.
+func decodeStructFields(d *Decoder, v reflect.Value, arrayLen int) error {
 	fields := structs.Fields(v.Type(), defaultStructTag)
 	for i := 0; i < arrayLen; i++ {
-		name, err := d.DecodeString()
+		name, err := d.DecodePropertyName()
 		if err != nil {
 			return err
 		}
@@ -22,12 +67,10 @@ func decodeStructValue(d *Decoder, v reflect.Value) error {
 			if err := f.DecodeValue(d, v); err != nil {
 				return err
 			}
-			} else if d.flags&disallowUnknownFieldsFlag != 0 {
-				return fmt.Errorf("phpserialize: unknown field %q", name)
-			//} else if err := d.Skip(); err != nil {
-			//	return err
+		} else if err := d.SkipProperty(name); err != nil {
+			return err
 		}
 	}
 
-	return d.skipExpected('}')
+	return d.DecodeArrayEnd()
 }
@@ -1,12 +1,17 @@
 package phpserialize
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"io/ioutil"
 	"math"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestUnmarshalInt8(t *testing.T) {
@@ -21,8 +26,8 @@ func TestUnmarshalInt8(t *testing.T) {
 	assert.Nil(t, Unmarshal([]byte(`a:1:{s:1:"v";i:123;}`), &container))
 	assert.Equal(t, int8(123), container.Value)
 
-	assert.EqualError(t, Unmarshal([]byte(`i:128;`), &v), `strconv.ParseInt: parsing "128": value out of range`)
-	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";i:-129;}`), &container), `strconv.ParseInt: parsing "-129": value out of range`)
+	assert.EqualError(t, Unmarshal([]byte(`i:128;`), &v), `phpserialize: cannot unmarshal integer 128 into Go value of type int8`)
+	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";i:-129;}`), &container), `phpserialize: cannot unmarshal integer -129 into Go field Value of type int8`)
 }
 
 func TestUnmarshalInt16(t *testing.T) {
@@ -37,8 +42,8 @@ func TestUnmarshalInt16(t *testing.T) {
 	assert.Nil(t, Unmarshal([]byte(`a:1:{s:1:"v";i:123;}`), &container))
 	assert.Equal(t, int16(123), container.Value)
 
-	assert.EqualError(t, Unmarshal([]byte(`i:32768;`), &v), `strconv.ParseInt: parsing "32768": value out of range`)
-	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";i:-32769;}`), &container), `strconv.ParseInt: parsing "-32769": value out of range`)
+	assert.EqualError(t, Unmarshal([]byte(`i:32768;`), &v), `phpserialize: cannot unmarshal integer 32768 into Go value of type int16`)
+	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";i:-32769;}`), &container), `phpserialize: cannot unmarshal integer -32769 into Go field Value of type int16`)
 }
 
 func TestUnmarshalInt32(t *testing.T) {
@@ -53,8 +58,8 @@ func TestUnmarshalInt32(t *testing.T) {
 	assert.Nil(t, Unmarshal([]byte(`a:1:{s:1:"v";i:123;}`), &container))
 	assert.Equal(t, int32(123), container.Value)
 
-	assert.EqualError(t, Unmarshal([]byte(`i:2147483648;`), &v), `strconv.ParseInt: parsing "2147483648": value out of range`)
-	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";i:-2147483649;}`), &container), `strconv.ParseInt: parsing "-2147483649": value out of range`)
+	assert.EqualError(t, Unmarshal([]byte(`i:2147483648;`), &v), `phpserialize: cannot unmarshal integer 2147483648 into Go value of type int32`)
+	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";i:-2147483649;}`), &container), `phpserialize: cannot unmarshal integer -2147483649 into Go field Value of type int32`)
 }
 
 func TestUnmarshalInt64(t *testing.T) {
@@ -69,8 +74,8 @@ func TestUnmarshalInt64(t *testing.T) {
 	assert.Nil(t, Unmarshal([]byte(`a:1:{s:1:"v";i:123;}`), &container))
 	assert.Equal(t, int64(123), container.Value)
 
-	assert.EqualError(t, Unmarshal([]byte(`i:9223372036854775808;`), &v), `strconv.ParseInt: parsing "9223372036854775808": value out of range`)
-	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";i:-9223372036854775809;}`), &container), `strconv.ParseInt: parsing "-9223372036854775809": value out of range`)
+	assert.EqualError(t, Unmarshal([]byte(`i:9223372036854775808;`), &v), `phpserialize: cannot unmarshal integer 9223372036854775808 into Go value of type int64`)
+	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";i:-9223372036854775809;}`), &container), `phpserialize: cannot unmarshal integer -9223372036854775809 into Go field Value of type int64`)
 }
 
 // assume testing happens on 64-bit system
@@ -86,8 +91,11 @@ func TestUnmarshalInt(t *testing.T) {
 	assert.Nil(t, Unmarshal([]byte(`a:1:{s:1:"v";i:123;}`), &container))
 	assert.Equal(t, 123, container.Value)
 
-	assert.EqualError(t, Unmarshal([]byte(`i:9223372036854775808;`), &v), `strconv.ParseInt: parsing "9223372036854775808": value out of range`)
-	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";i:-9223372036854775809;}`), &container), `strconv.ParseInt: parsing "-9223372036854775809": value out of range`)
+	assert.EqualError(t, Unmarshal([]byte(`i:9223372036854775808;`), &v), `phpserialize: cannot unmarshal integer 9223372036854775808 into Go value of type int`)
+	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";i:-9223372036854775809;}`), &container), `phpserialize: cannot unmarshal integer -9223372036854775809 into Go field Value of type int`)
+
+	// strings are decoded without copying them
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() { _ = UnmarshalString(`i:42;`, &v) }))
 }
 
 func TestUnmarshalBool(t *testing.T) {
@@ -118,8 +126,8 @@ func TestUnmarshalFloat32(t *testing.T) {
 	assert.Nil(t, Unmarshal([]byte(`a:1:{s:1:"v";d:15235.12825;}`), &container))
 	assert.Equal(t, float32(15235.12825), container.Value)
 
-	assert.EqualError(t, Unmarshal([]byte(`d:3.402823466e+50;`), &v), `strconv.ParseFloat: parsing "3.402823466e+50": value out of range`)
-	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";d:3.402823466e+50;}`), &container), `strconv.ParseFloat: parsing "3.402823466e+50": value out of range`)
+	assert.EqualError(t, Unmarshal([]byte(`d:3.402823466e+50;`), &v), `phpserialize: cannot unmarshal float 3.402823466e+50 into Go value of type float32`)
+	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";d:3.402823466e+50;}`), &container), `phpserialize: cannot unmarshal float 3.402823466e+50 into Go field Value of type float32`)
 }
 
 func TestUnmarshalFloat64(t *testing.T) {
@@ -143,8 +151,21 @@ func TestUnmarshalFloat64(t *testing.T) {
 	assert.Nil(t, UnmarshalString(`d:NAN;`, &v))
 	assert.True(t, math.IsNaN(v))
 
-	assert.EqualError(t, Unmarshal([]byte(`d:3.402823466e+325;`), &v), `strconv.ParseFloat: parsing "3.402823466e+325": value out of range`)
-	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";d:3.402823466e+325;}`), &container), `strconv.ParseFloat: parsing "3.402823466e+325": value out of range`)
+	for text, want := range map[string]float64{
+		`1.0E+25`:             1e25,
+		`1.0E-5`:              1e-5,
+		`1e5`:                 1e5,
+		`.5`:                  0.5,
+		`5.`:                  5,
+		`+1.5`:                1.5,
+		`0.10000000000000001`: 0.1,
+	} {
+		assert.Nil(t, UnmarshalString(`d:`+text+`;`, &v))
+		assert.Equal(t, want, v, text)
+	}
+
+	assert.EqualError(t, Unmarshal([]byte(`d:3.402823466e+325;`), &v), `phpserialize: cannot unmarshal float 3.402823466e+325 into Go value of type float64`)
+	assert.EqualError(t, Unmarshal([]byte(`a:1:{s:1:"v";d:3.402823466e+325;}`), &container), `phpserialize: cannot unmarshal float 3.402823466e+325 into Go field Value of type float64`)
 }
 
 func TestUnmarshalSlice(t *testing.T) {
@@ -181,10 +202,1081 @@ func TestDecoder_DecodeFloat(t *testing.T) {
 	d := NewDecoder(strings.NewReader(`b:1;`))
 	v, err := d.DecodeFloat(64)
 	assert.Zero(t, v)
-	assert.EqualError(t, err, `phpserialize: Decode(expected byte 'd' found 'b')`)
+	assert.EqualError(t, err, `phpserialize: cannot unmarshal bool into Go value of type float64`)
+
+	d = NewDecoder(strings.NewReader(`x:1;`))
+	_, err = d.DecodeFloat(64)
+	assert.EqualError(t, err, `phpserialize: Decode(expected byte 'd' found 'x')`)
 
 	d = NewDecoder(strings.NewReader(`d:`))
 	v, err = d.DecodeFloat(64)
 	assert.Zero(t, v)
 	assert.Equal(t, io.EOF, err)
+
+	// only the spellings PHP accepts decode, as Validate checks
+	for data, expected := range map[string]float64{`d:1.;`: 1, `d:.5;`: 0.5, `d:+2E-1;`: 0.2, `d:-INF;`: math.Inf(-1)} {
+		var f float64
+		assert.Nil(t, UnmarshalString(data, &f), data)
+		assert.Equal(t, expected, f, data)
+	}
+	for _, data := range []string{`d:0x1p3;`, `d:Infinity;`, `d:inf;`, `d:1_0;`, `d:+INF;`, `d:nan;`, `d:1e;`} {
+		var f float64
+		assert.EqualError(t, UnmarshalString(data, &f), fmt.Sprintf(`phpserialize: Decode(invalid float %q)`, data[2:len(data)-1]), data)
+		assert.False(t, Valid([]byte(data)), data)
+		var tree Value
+		assert.NotNil(t, UnmarshalString(data, &tree), data)
+	}
+}
+
+func TestUnmarshalTime(t *testing.T) {
+	var v time.Time
+
+	assert.Nil(t, UnmarshalString(`i:1620000000;`, &v))
+	assert.True(t, time.Unix(1620000000, 0).Equal(v))
+	assert.Equal(t, time.UTC, v.Location())
+
+	assert.Nil(t, UnmarshalString(`s:19:"2021-05-03 00:00:00";`, &v))
+	assert.Equal(t, time.Date(2021, 5, 3, 0, 0, 0, 0, time.UTC), v)
+
+	assert.Nil(t, UnmarshalString(`O:8:"DateTime":3:{s:4:"date";s:26:"2021-05-03 12:30:15.250000";s:13:"timezone_type";i:1;s:8:"timezone";s:6:"+02:00";}`, &v))
+	assert.Equal(t, `2021-05-03T12:30:15.25+02:00`, v.Format(time.RFC3339Nano))
+
+	assert.Nil(t, UnmarshalString(`O:17:"DateTimeImmutable":3:{s:4:"date";s:26:"2021-05-03 12:30:15.000000";s:13:"timezone_type";i:2;s:8:"timezone";s:3:"EST";}`, &v))
+	assert.Equal(t, `2021-05-03T12:30:15-05:00`, v.Format(time.RFC3339Nano))
+
+	// subclasses decode too, skipping the properties they add
+	assert.Nil(t, UnmarshalString(`O:13:"Carbon\Carbon":5:{s:4:"date";s:26:"2021-05-03 12:30:15.000000";s:13:"timezone_type";i:3;s:8:"timezone";s:3:"UTC";`+
+		`s:9:"endOfTime";b:0;s:19:"constructedObjectId";s:32:"0000000064a1b2c3000000003f2e1d0c";}`, &v))
+	assert.Equal(t, time.Date(2021, 5, 3, 12, 30, 15, 0, time.UTC), v)
+
+	container := struct {
+		Created time.Time  `php:"created"`
+		Updated *time.Time `php:"updated"`
+	}{}
+	assert.Nil(t, UnmarshalString(`a:2:{s:7:"created";i:1620000000;s:7:"updated";N;}`, &container))
+	assert.True(t, time.Unix(1620000000, 0).Equal(container.Created))
+	assert.Nil(t, container.Updated)
+
+	assert.EqualError(t, UnmarshalString(`s:5:"later";`, &v), `phpserialize: cannot unmarshal string "later" into Go value of type time.Time`)
+	assert.EqualError(t, UnmarshalString(`O:8:"DateTime":3:{s:4:"date";s:26:"2021-05-03 12:30:15.000000";s:13:"timezone_type";i:2;s:8:"timezone";s:3:"XYZ";}`, &v), `phpserialize: cannot unmarshal timezone "XYZ" of type 2 into Go value of type time.Time`)
+}
+
+func TestDecoder_SetTimeLocation(t *testing.T) {
+	loc := time.FixedZone(`+03:00`, 3*3600)
+	d := NewDecoder(strings.NewReader(`s:19:"2021-05-03 00:00:00";`))
+	d.SetTimeLocation(loc)
+
+	v, err := d.DecodeTime()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 5, 3, 0, 0, 0, 0, loc), v)
+}
+
+func TestUnmarshalDuration(t *testing.T) {
+	var v time.Duration
+	assert.Nil(t, UnmarshalString(`i:1500000000;`, &v))
+	assert.Equal(t, 1500*time.Millisecond, v)
+
+	container := struct {
+		Timeout time.Duration  `php:"timeout,seconds"`
+		Delay   *time.Duration `php:"delay,seconds"`
+	}{}
+	assert.Nil(t, UnmarshalString(`a:2:{s:7:"timeout";i:30;s:5:"delay";d:1.5;}`, &container))
+	assert.Equal(t, 30*time.Second, container.Timeout)
+	if assert.NotNil(t, container.Delay) {
+		assert.Equal(t, 1500*time.Millisecond, *container.Delay)
+	}
+}
+
+func TestUnmarshalDateInterval(t *testing.T) {
+	var v DateInterval
+
+	// PHP 7 layout
+	assert.Nil(t, UnmarshalString(`O:12:"DateInterval":16:{s:1:"y";i:1;s:1:"m";i:2;s:1:"d";i:3;s:1:"h";i:4;s:1:"i";i:5;s:1:"s";i:6;s:1:"f";d:0.5;`+
+		`s:7:"weekday";i:0;s:16:"weekday_behavior";i:0;s:17:"first_last_day_of";i:0;s:6:"invert";i:1;s:4:"days";i:428;`+
+		`s:12:"special_type";i:0;s:14:"special_amount";i:0;s:21:"have_weekday_relative";i:0;s:21:"have_special_relative";i:0;}`, &v))
+	days := 428
+	assert.Equal(t, DateInterval{Y: 1, M: 2, D: 3, H: 4, I: 5, S: 6, F: 0.5, Invert: true, Days: &days}, v)
+
+	// PHP 8.2 layout
+	assert.Nil(t, UnmarshalString(`O:12:"DateInterval":10:{s:1:"y";i:0;s:1:"m";i:0;s:1:"d";i:7;s:1:"h";i:0;s:1:"i";i:0;s:1:"s";i:0;s:1:"f";d:0;`+
+		`s:6:"invert";i:0;s:4:"days";b:0;s:11:"from_string";b:0;}`, &v))
+	assert.Equal(t, DateInterval{D: 7}, v)
+
+	assert.EqualError(t, UnmarshalString(`O:8:"DateTime":0:{}`, &v), `phpserialize: cannot unmarshal object DateTime into Go value of type phpserialize.DateInterval`)
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	type Item struct {
+		Price int8 `php:"price"`
+	}
+	type Order struct {
+		Items []Item          `php:"items"`
+		Tags  map[string]int8 `php:"tags"`
+	}
+
+	var order Order
+	err := UnmarshalString(`a:1:{s:5:"items";a:2:{i:0;a:1:{s:5:"price";i:1;}i:1;a:1:{s:5:"price";i:300;}}}`, &order)
+	var typeErr *UnmarshalTypeError
+	if assert.True(t, errors.As(err, &typeErr)) {
+		assert.Equal(t, `Order.Items[1].Price`, typeErr.Field)
+		assert.Equal(t, `integer 300`, typeErr.Value)
+		assert.Equal(t, reflect.TypeOf(int8(0)), typeErr.Type)
+		assert.Equal(t, int64(69), typeErr.Offset)
+	}
+	assert.EqualError(t, err, `phpserialize: cannot unmarshal integer 300 into Go field Order.Items[1].Price of type int8`)
+
+	err = UnmarshalString(`a:1:{s:4:"tags";a:1:{s:3:"red";i:-200;}}`, &order)
+	assert.EqualError(t, err, `phpserialize: cannot unmarshal integer -200 into Go field Order.Tags[red] of type int8`)
+
+	err = UnmarshalString(`a:1:{s:5:"items";a:1:{i:4;a:0:{}}}`, &order)
+	assert.EqualError(t, err, `phpserialize: cannot unmarshal array key 4 at position 0 into Go field Order.Items of type []phpserialize.Item`)
+
+	unsigned := struct {
+		Value uint8 `php:"v"`
+	}{}
+	assert.EqualError(t, UnmarshalString(`a:1:{s:1:"v";i:-1;}`, &unsigned), `phpserialize: cannot unmarshal integer -1 into Go field Value of type uint8`)
+
+	var c complex64
+	assert.EqualError(t, UnmarshalString(`i:1;`, &c), `phpserialize: cannot unmarshal integer into Go value of type complex64`)
+
+	err = UnmarshalString(`a:1:{s:5:"items";a:1:{i:0;a:1:{s:5:"price";b:1;}}}`, &order)
+	if assert.True(t, errors.As(err, &typeErr)) {
+		assert.Equal(t, `Order.Items[0].Price`, typeErr.Field)
+		assert.Equal(t, `bool`, typeErr.Value)
+		assert.Equal(t, int64(43), typeErr.Offset)
+	}
+	assert.EqualError(t, err, `phpserialize: cannot unmarshal bool into Go field Order.Items[0].Price of type int8`)
+	assert.EqualError(t, UnmarshalString(`a:1:{s:5:"items";s:1:"x";}`, &order), `phpserialize: cannot unmarshal string into Go field Order.Items of type []phpserialize.Item`)
+
+	var syntaxErr *SyntaxError
+
+	var i int
+	err = UnmarshalString(`i:12x;`, &i)
+	if assert.True(t, errors.As(err, &syntaxErr)) {
+		assert.Equal(t, int64(0), syntaxErr.Offset)
+	}
+	assert.EqualError(t, err, `phpserialize: Decode(invalid integer "12x")`)
+
+	var s string
+	assert.EqualError(t, UnmarshalString(`s:-1:"";`, &s), `phpserialize: Decode(invalid length "-1")`)
+	assert.EqualError(t, UnmarshalString(`s:+1:"a";`, &s), `phpserialize: Decode(invalid length "+1")`)
+	assert.EqualError(t, UnmarshalString(`a:+0:{}`, new([]int)), `phpserialize: Decode(invalid length "+0")`)
+	assert.EqualError(t, UnmarshalString(`O:+8:"stdClass":0:{}`, new(interface{})), `phpserialize: Decode(invalid length "+8")`)
+	assert.False(t, Valid([]byte(`s:+1:"a";`)))
+	_, err = Get([]byte(`a:1:{i:0;s:+1:"a";}`), `0`)
+	assert.EqualError(t, err, `phpserialize: Decode(invalid length "+1")`)
+
+	err = UnmarshalString(`a:1:{s:5:"items";a:1:{i:0;a:1:{s:5:"pr`, &order)
+	if assert.True(t, errors.As(err, &syntaxErr)) {
+		assert.Equal(t, int64(38), syntaxErr.Offset)
+	}
+	assert.EqualError(t, err, `phpserialize: Decode(unexpected end of input)`)
+	assert.EqualError(t, UnmarshalString(``, &s), `phpserialize: Decode(unexpected end of input)`)
+	assert.Equal(t, io.EOF, NewDecoder(strings.NewReader(``)).Decode(&s))
+}
+
+func TestDecoderLimits(t *testing.T) {
+	decode := func(data string, configure func(d *Decoder), v interface{}) error {
+		d := NewDecoder(strings.NewReader(data))
+		configure(d)
+		return d.Decode(v)
+	}
+
+	var m map[string]int
+	var limitErr *LimitError
+
+	err := decode(`a:1:{i:0;a:1:{i:0;a:1:{i:0;a:0:{}}}}`, func(d *Decoder) { d.SetMaxDepth(2) }, &[][][][]int{})
+	if assert.True(t, errors.As(err, &limitErr)) {
+		assert.Equal(t, `depth`, limitErr.Limit)
+		assert.Equal(t, int64(18), limitErr.Offset)
+	}
+	assert.EqualError(t, err, `phpserialize: Decode(input exceeds max depth of 2)`)
+	assert.Nil(t, decode(`a:1:{i:0;a:1:{i:0;a:1:{i:0;a:0:{}}}}`, func(d *Decoder) { d.SetMaxDepth(4) }, &[][][][]int{}))
+
+	var s string
+	err = decode(`s:1000000000000:"`, func(d *Decoder) { d.SetMaxStringLength(64) }, &s)
+	assert.EqualError(t, err, `phpserialize: Decode(input exceeds max string length of 64)`)
+	// without a limit the length alone must not allocate
+	assert.EqualError(t, decode(`s:1000000000000:"abc`, func(d *Decoder) {}, &s), `phpserialize: Decode(unexpected end of input)`)
+
+	err = decode(`a:100000000:{`, func(d *Decoder) { d.SetMaxElements(1000) }, &m)
+	assert.EqualError(t, err, `phpserialize: Decode(input exceeds max elements of 1000)`)
+
+	err = decode(`s:10:"0123456789";`, func(d *Decoder) { d.SetMaxBytes(10) }, &s)
+	if assert.True(t, errors.As(err, &limitErr)) {
+		assert.Equal(t, `bytes`, limitErr.Limit)
+		assert.Equal(t, int64(10), limitErr.Offset)
+	}
+
+	var i int
+	err = UnmarshalString(`i:`+strings.Repeat(`1`, 2000)+`;`, &i)
+	assert.EqualError(t, err, `phpserialize: Decode(expected byte ';' within 1024 bytes)`)
+}
+
+func TestUnmarshalEmptyArrays(t *testing.T) {
+	container := struct {
+		Map   map[string]int `php:"m"`
+		Slice []int          `php:"s"`
+		After int            `php:"a"`
+	}{}
+	assert.Nil(t, UnmarshalString(`a:3:{s:1:"m";a:0:{}s:1:"s";a:0:{}s:1:"a";i:7;}`, &container))
+	assert.Equal(t, map[string]int{}, container.Map)
+	assert.Equal(t, []int{}, container.Slice)
+	assert.Equal(t, 7, container.After)
+}
+
+func TestUnmarshalInterface(t *testing.T) {
+	var v interface{}
+	assert.Nil(t, UnmarshalString(`a:3:{i:0;N;i:1;b:1;i:2;a:2:{s:1:"a";i:5;i:7;d:1.5;}}`, &v))
+	assert.Equal(t, []interface{}{nil, true, map[interface{}]interface{}{`a`: int64(5), int64(7): 1.5}}, v)
+
+	v = nil
+	assert.Nil(t, UnmarshalString(`a:2:{i:0;s:1:"x";i:5;s:1:"y";}`, &v))
+	assert.Equal(t, map[interface{}]interface{}{int64(0): `x`, int64(5): `y`}, v)
+
+	var m map[string]interface{}
+	assert.Nil(t, UnmarshalString(`a:1:{s:4:"name";s:3:"Bob";}`, &m))
+	assert.Equal(t, map[string]interface{}{`name`: `Bob`}, m)
+
+	v = nil
+	assert.EqualError(t, UnmarshalString(`a:1:{i:0;r:1;}`, &v), `phpserialize: cannot unmarshal reference into Go field [0] of type interface {}`)
+}
+
+func TestUnmarshalObject(t *testing.T) {
+	type User struct {
+		ID    int    `php:"id"`
+		Email string `php:"email"`
+		Name  string `php:"name"`
+	}
+
+	var u User
+	assert.Nil(t, UnmarshalString("O:4:\"User\":3:{s:2:\"id\";i:7;s:8:\"\x00*\x00email\";s:5:\"a@b.c\";s:10:\"\x00User\x00name\";s:3:\"Ann\";}", &u))
+	assert.Equal(t, User{ID: 7, Email: `a@b.c`, Name: `Ann`}, u)
+
+	var v interface{}
+	assert.Nil(t, UnmarshalString("O:4:\"User\":2:{s:2:\"id\";i:7;s:8:\"\x00*\x00email\";s:5:\"a@b.c\";}", &v))
+	assert.Equal(t, IncompleteObject{
+		Class: `User`,
+		Properties: []ObjectProperty{
+			{Name: `id`, Value: []byte(`i:7;`)},
+			{Name: "\x00*\x00email", Value: []byte(`s:5:"a@b.c";`)},
+		},
+	}, v)
+
+	var obj IncompleteObject
+	assert.Nil(t, UnmarshalString(`C:11:"ArrayObject":21:{x:i:0;a:0:{};m:a:0:{}}`, &obj))
+	assert.Equal(t, IncompleteObject{Class: `ArrayObject`, Custom: true, Data: []byte(`x:i:0;a:0:{};m:a:0:{}`)}, obj)
+}
+
+func TestDecoder_SetAllowedClasses(t *testing.T) {
+	type Foo struct {
+		A int `php:"a"`
+	}
+	decode := func(data string, configure func(d *Decoder), v interface{}) error {
+		d := NewDecoder(strings.NewReader(data))
+		configure(d)
+		return d.Decode(v)
+	}
+	foo := `O:3:"Foo":1:{s:1:"a";i:1;}`
+	bar := `O:3:"Bar":1:{s:1:"a";a:1:{i:0;O:3:"Foo":0:{}}}`
+
+	var f Foo
+	assert.Nil(t, decode(foo, func(d *Decoder) { d.SetAllowedClasses(`foo`) }, &f))
+	assert.Equal(t, 1, f.A)
+
+	// disallowed classes cannot decode into concrete types
+	var notAllowed *ClassNotAllowedError
+	err := decode(foo, func(d *Decoder) { d.SetAllowedClasses(`Bar`) }, &f)
+	if assert.True(t, errors.As(err, &notAllowed)) {
+		assert.Equal(t, `Foo`, notAllowed.Class)
+		assert.Equal(t, int64(0), notAllowed.Offset)
+	}
+	assert.EqualError(t, err, `phpserialize: Decode(class "Foo" is not allowed)`)
+
+	var tm time.Time
+	assert.EqualError(t, decode(`O:8:"DateTime":0:{}`, func(d *Decoder) { d.SetAllowedClasses() }, &tm), `phpserialize: Decode(class "DateTime" is not allowed)`)
+
+	// but become placeholders where one fits
+	var v interface{}
+	assert.Nil(t, decode(bar, func(d *Decoder) { d.SetAllowedClasses() }, &v))
+	assert.Equal(t, `Bar`, v.(IncompleteObject).Class)
+
+	v = nil
+	err = decode(bar, func(d *Decoder) {
+		d.SetAllowedClasses(`Foo`)
+		d.ErrorOnDisallowedClass(true)
+	}, &v)
+	assert.EqualError(t, err, `phpserialize: Decode(class "Bar" is not allowed)`)
+
+	// classes nested in the properties of an allowed object are checked too
+	for _, data := range []string{bar, `O:3:"Bar":1:{s:1:"a";C:3:"Foo":0:{}}`} {
+		v = nil
+		err = decode(data, func(d *Decoder) {
+			d.SetAllowedClasses(`Bar`)
+			d.ErrorOnDisallowedClass(true)
+		}, &v)
+		if assert.True(t, errors.As(err, &notAllowed)) {
+			assert.Equal(t, `Foo`, notAllowed.Class)
+		}
+	}
+	v = nil
+	assert.Nil(t, decode(bar, func(d *Decoder) {
+		d.SetAllowedClasses(`Bar`, `Foo`)
+		d.ErrorOnDisallowedClass(true)
+	}, &v))
+	v = nil
+
+	assert.Nil(t, decode(bar, func(d *Decoder) {
+		d.SetAllowedClasses(`Foo`)
+		d.ErrorOnDisallowedClass(true)
+		d.AllowAllClasses()
+	}, &v))
+}
+
+func TestScanner(t *testing.T) {
+	s := NewScanner(strings.NewReader(`a:2:{i:0;s:1:"x";s:1:"o";O:3:"Foo":1:{s:1:"a";d:0.5;}}b:1;C:3:"Bar":2:{xy}r:2;`))
+
+	var tokens []Token
+	for {
+		tok, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		tokens = append(tokens, tok)
+	}
+	assert.Equal(t, []Token{
+		{Kind: TokenArrayStart, Code: 'a', Offset: 0, Len: 2},
+		{Kind: TokenKey, Code: 'i', Offset: 5, Int: 0},
+		{Kind: TokenString, Code: 's', Offset: 9, String: `x`},
+		{Kind: TokenKey, Code: 's', Offset: 17, String: `o`},
+		{Kind: TokenObjectStart, Code: 'O', Offset: 25, Class: `Foo`, Len: 1},
+		{Kind: TokenKey, Code: 's', Offset: 38, String: `a`},
+		{Kind: TokenFloat, Code: 'd', Offset: 46, Float: 0.5},
+		{Kind: TokenEnd, Code: '}', Offset: 52},
+		{Kind: TokenEnd, Code: '}', Offset: 53},
+		{Kind: TokenBool, Code: 'b', Offset: 54, Bool: true},
+		{Kind: TokenCustom, Code: 'C', Offset: 58, Class: `Bar`, Len: 2, Data: []byte(`xy`)},
+		{Kind: TokenRef, Code: 'r', Offset: 74, Int: 2},
+	}, tokens)
+
+	s = NewScanner(strings.NewReader(`a:2:{i:0;a:1:{i:0;i:1;}i:1;i:2;}`))
+	tok, _ := s.Next()
+	assert.Equal(t, TokenArrayStart, tok.Kind)
+	tok, _ = s.Next()
+	assert.Equal(t, TokenKey, tok.Kind)
+	assert.Nil(t, s.Skip())
+	tok, _ = s.Next()
+	assert.Equal(t, Token{Kind: TokenKey, Code: 'i', Offset: 23, Int: 1}, tok)
+	assert.Nil(t, s.Skip())
+	assert.EqualError(t, s.Skip(), `phpserialize: Skip(next token is not a value)`)
+	tok, _ = s.Next()
+	assert.Equal(t, TokenEnd, tok.Kind)
+	assert.Equal(t, 0, s.Depth())
+
+	s = NewScanner(strings.NewReader(`a:1:{i:0;`))
+	_, _ = s.Next()
+	_, _ = s.Next()
+	_, err := s.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// input ending within a top-level token is not a clean end either
+	for _, data := range []string{`i:12`, `s:5:"ab`, `a:2:`, `O:3:"Foo`, `b:1;i:`} {
+		s = NewScanner(strings.NewReader(data))
+		var err error
+		for err == nil {
+			_, err = s.Next()
+		}
+		assert.Equal(t, io.ErrUnexpectedEOF, err, data)
+
+		s = NewScanner(strings.NewReader(data))
+		for err = nil; err == nil; {
+			err = s.Skip()
+		}
+		assert.Equal(t, io.ErrUnexpectedEOF, err, data)
+	}
+	s = NewScanner(strings.NewReader(`i:12;`))
+	_, _ = s.Next()
+	_, err = s.Next()
+	assert.Equal(t, io.EOF, err)
+
+	s = NewScanner(strings.NewReader(`a:1:{d:1;i:0;}`))
+	_, _ = s.Next()
+	_, err = s.Next()
+	assert.EqualError(t, err, `phpserialize: Decode(invalid array key 'd')`)
+}
+
+func TestUnmarshalCustom(t *testing.T) {
+	type Release struct {
+		Version version  `php:"version"`
+		Price   cents    `php:"price"`
+		Next    *version `php:"next"`
+	}
+	var r Release
+	assert.Nil(t, UnmarshalString(`a:3:{s:7:"version";s:3:"7.4";s:5:"price";d:9.99;s:4:"next";s:3:"8.0";}`, &r))
+	assert.Equal(t, Release{Version: version{7, 4}, Price: 999, Next: &version{8, 0}}, r)
+
+	assert.Nil(t, UnmarshalString(`a:1:{s:4:"next";N;}`, &r))
+	assert.Nil(t, r.Next)
+
+	var c cents
+	assert.Nil(t, UnmarshalString(`d:0.1;`, &c))
+	assert.Equal(t, cents(10), c)
+}
+
+func TestUnmarshalRawMessage(t *testing.T) {
+	type Envelope struct {
+		Type    string     `php:"type"`
+		Payload RawMessage `php:"payload"`
+	}
+
+	var e Envelope
+	data := `a:3:{s:4:"type";s:5:"order";s:5:"extra";a:1:{i:0;O:3:"Foo":0:{}}s:7:"payload";a:1:{s:2:"id";i:7;}}`
+	assert.Nil(t, UnmarshalString(data, &e))
+	assert.Equal(t, Envelope{Type: `order`, Payload: RawMessage(`a:1:{s:2:"id";i:7;}`)}, e)
+
+	var raw RawMessage
+	assert.Nil(t, UnmarshalString(`s:3:"a;b";`, &raw))
+	assert.Equal(t, RawMessage(`s:3:"a;b";`), raw)
+}
+
+func TestUnmarshalValue(t *testing.T) {
+	data := `a:4:{s:4:"name";s:3:"Ann";i:7;d:0.50;s:4:"user";O:4:"User":2:{s:8:"` + "\x00*\x00email" + `";s:5:"a@b.c";s:2:"id";i:-0;}s:4:"self";R:2;}`
+
+	var v Value
+	assert.Nil(t, UnmarshalString(data, &v))
+	assert.Equal(t, KindArray, v.Kind())
+	assert.Equal(t, 4, v.Len())
+	assert.Equal(t, []Value{*StringValue(`name`), *IntValue(7), *StringValue(`user`), *StringValue(`self`)}, v.Keys())
+	assert.Equal(t, `Ann`, v.Get(`name`).Str())
+	assert.Equal(t, 0.5, v.Get(`7`).Float())
+	assert.Equal(t, `0.50`, v.Index(1).Str())
+	assert.Equal(t, `User`, v.Get(`user`).Class())
+	assert.Equal(t, `a@b.c`, v.Get(`user`).Get(`email`).Str())
+	assert.Equal(t, KindRef, v.Get(`self`).Kind())
+	assert.True(t, v.Get(`self`).ByReference())
+	assert.Equal(t, int64(2), v.Get(`self`).Int())
+	assert.Nil(t, v.Get(`missing`))
+
+	b, err := Marshal(v)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(b))
+
+	v.Get(`user`).Set(`id`, IntValue(8))
+	assert.True(t, v.Delete(7))
+	assert.False(t, v.Delete(7))
+	v.Append(BoolValue(true))
+	v.Set(`name`, StringValue(`Bob`))
+	b, err = Marshal(&v)
+	assert.Nil(t, err)
+	assert.Equal(t, `a:4:{s:4:"name";s:3:"Bob";s:4:"user";O:4:"User":2:{s:8:"`+"\x00*\x00email"+`";s:5:"a@b.c";s:2:"id";i:8;}s:4:"self";R:2;i:0;b:1;}`, string(b))
+
+	// references are renumbered for values added or removed before them
+	edit := func(data string, change func(v *Value)) (string, error) {
+		var v Value
+		assert.Nil(t, UnmarshalString(data, &v))
+		change(&v)
+		b, err := Marshal(&v)
+		return string(b), err
+	}
+	b2, err := edit(`a:3:{i:0;i:9;i:1;O:1:"A":0:{}i:2;r:3;}`, func(v *Value) { v.Delete(0) })
+	assert.Nil(t, err)
+	assert.Equal(t, `a:2:{i:1;O:1:"A":0:{}i:2;r:2;}`, b2)
+	b2, err = edit(`a:3:{i:0;a:0:{}i:1;O:1:"A":0:{}i:2;r:3;}`, func(v *Value) { v.Index(0).Append(IntValue(1)) })
+	assert.Nil(t, err)
+	assert.Equal(t, `a:3:{i:0;a:1:{i:0;i:1;}i:1;O:1:"A":0:{}i:2;r:4;}`, b2)
+	b2, err = edit(`a:2:{i:0;a:1:{i:0;i:5;}i:1;R:3;}`, func(v *Value) { v.Index(0).Set(0, StringValue(`x`)) })
+	assert.Nil(t, err)
+	assert.Equal(t, `a:2:{i:0;a:1:{i:0;s:1:"x";}i:1;R:3;}`, b2)
+	b2, err = edit(`O:1:"A":1:{s:4:"self";r:1;}`, func(v *Value) { v.Set(`n`, IntValue(1)) })
+	assert.Nil(t, err)
+	assert.Equal(t, `O:1:"A":2:{s:4:"self";r:1;s:1:"n";i:1;}`, b2)
+	_, err = edit(`a:3:{i:0;O:1:"A":0:{}i:1;a:1:{i:0;i:5;}i:2;r:2;}`, func(v *Value) { v.Delete(0) })
+	assert.EqualError(t, err, `phpserialize: EncodeTree(reference to a value that is not written before it)`)
+
+	assert.EqualError(t, UnmarshalString(`i:1x;`, &v), `phpserialize: Decode(invalid integer "1x")`)
+	assert.EqualError(t, UnmarshalString(`a:1:{d:1;i:1;}`, &v), `phpserialize: Decode(invalid array key 'd')`)
+	assert.Panics(t, func() { IntValue(1).Set(`a`, NullValue()) })
+}
+
+func TestGet(t *testing.T) {
+	data := []byte(`a:3:{s:7:"options";a:1:{s:5:"theme";a:1:{s:6:"colors";a:2:{i:0;s:3:"red";i:1;s:4:"blue";}}}` +
+		`s:5:"users";a:2:{i:0;O:4:"User":1:{s:8:"` + "\x00*\x00email" + `";s:5:"a@b.c";}i:1;O:4:"User":1:{s:8:"` + "\x00*\x00email" + `";s:5:"d@e.f";}}` +
+		`s:3:"a.b";i:1;}`)
+
+	r, err := Get(data, `options.theme.colors.0`)
+	assert.Nil(t, err)
+	assert.Equal(t, RawMessage(`s:3:"red";`), r.Raw)
+	assert.Equal(t, 63, r.Index)
+	var s string
+	assert.Nil(t, r.Unmarshal(&s))
+	assert.Equal(t, `red`, s)
+
+	r, err = Get(data, `users.1.email`)
+	assert.Nil(t, err)
+	assert.Equal(t, RawMessage(`s:5:"d@e.f";`), r.Raw)
+
+	r, err = Get(data, `users.*.email`)
+	assert.Nil(t, err)
+	assert.Equal(t, RawMessage(`a:2:{i:0;s:5:"a@b.c";i:1;s:5:"d@e.f";}`), r.Raw)
+	assert.Equal(t, -1, r.Index)
+
+	r, err = Get(data, `options.th?me.col*.1`)
+	assert.Nil(t, err)
+	assert.Equal(t, RawMessage(`a:1:{i:0;s:4:"blue";}`), r.Raw)
+
+	r, err = Get(data, `a\.b`)
+	assert.Nil(t, err)
+	assert.Equal(t, RawMessage(`i:1;`), r.Raw)
+
+	r, err = Get(data, `options.missing`)
+	assert.Nil(t, err)
+	assert.False(t, r.Exists())
+
+	r, err = Get(data, `users.*.phone`)
+	assert.Nil(t, err)
+	assert.False(t, r.Exists())
+	assert.Equal(t, Result{Index: -1}, r)
+
+	r, err = Get(data, ``)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), len(r.Raw))
+
+	_, err = Get([]byte(`a:1:{s:1:"a";s:9:"short";}`), `a`)
+	assert.EqualError(t, err, `phpserialize: Decode(string of length 9 exceeds input)`)
+}
+
+func TestSetDelete(t *testing.T) {
+	data := []byte(`a:2:{s:7:"options";a:1:{s:5:"theme";s:4:"dark";}s:4:"user";O:4:"User":1:{s:8:"` + "\x00User\x00id" + `";i:7;}}`)
+
+	b, err := Set(data, `options.theme`, `light`)
+	assert.Nil(t, err)
+	assert.Equal(t, `a:2:{s:7:"options";a:1:{s:5:"theme";s:5:"light";}s:4:"user";O:4:"User":1:{s:8:"`+"\x00User\x00id"+`";i:7;}}`, string(b))
+
+	b, err = Set(data, `user.id`, RawMessage(`i:8;`))
+	assert.Nil(t, err)
+	assert.Equal(t, `a:2:{s:7:"options";a:1:{s:5:"theme";s:4:"dark";}s:4:"user";O:4:"User":1:{s:8:"`+"\x00User\x00id"+`";i:8;}}`, string(b))
+
+	b, err = Set(data, `options.colors.0`, `red`)
+	assert.Nil(t, err)
+	assert.Equal(t, `a:2:{s:7:"options";a:2:{s:5:"theme";s:4:"dark";s:6:"colors";a:1:{i:0;s:3:"red";}}s:4:"user";O:4:"User":1:{s:8:"`+"\x00User\x00id"+`";i:7;}}`, string(b))
+	var v map[string]interface{}
+	assert.Nil(t, Unmarshal(b, &v))
+
+	b, err = Delete(data, `options.theme`)
+	assert.Nil(t, err)
+	assert.Equal(t, `a:2:{s:7:"options";a:0:{}s:4:"user";O:4:"User":1:{s:8:"`+"\x00User\x00id"+`";i:7;}}`, string(b))
+
+	b, err = Delete(data, `user`)
+	assert.Nil(t, err)
+	assert.Equal(t, `a:1:{s:7:"options";a:1:{s:5:"theme";s:4:"dark";}}`, string(b))
+
+	b, err = Delete(data, `options.missing`)
+	assert.Nil(t, err)
+	assert.Equal(t, data, b)
+
+	_, err = Set(data, `options.theme.x`, 1)
+	assert.EqualError(t, err, `phpserialize: path segment "x" is not within an array or object`)
+	_, err = Set(data, `options.*`, 1)
+	assert.EqualError(t, err, `phpserialize: wildcard path segment "*" cannot be modified`)
+
+	// References after the edited key are renumbered.
+	refs := []byte(`a:3:{s:1:"a";a:1:{i:0;i:1;}s:1:"b";O:1:"X":0:{}s:1:"c";R:4;}`)
+	for _, tt := range []struct {
+		edit     func() ([]byte, error)
+		expected string
+	}{
+		{func() ([]byte, error) { return Set(refs, `a.1`, 2) }, `a:3:{s:1:"a";a:2:{i:0;i:1;i:1;i:2;}s:1:"b";O:1:"X":0:{}s:1:"c";R:5;}`},
+		{func() ([]byte, error) { return Set(refs, `a`, `x`) }, `a:3:{s:1:"a";s:1:"x";s:1:"b";O:1:"X":0:{}s:1:"c";R:3;}`},
+		{func() ([]byte, error) { return Delete(refs, `a`) }, `a:2:{s:1:"b";O:1:"X":0:{}s:1:"c";R:2;}`},
+		{func() ([]byte, error) { return Set(refs, `d`, RawMessage(`a:2:{i:0;i:5;i:1;R:2;}`)) }, `a:4:{s:1:"a";a:1:{i:0;i:1;}s:1:"b";O:1:"X":0:{}s:1:"c";R:4;s:1:"d";a:2:{i:0;i:5;i:1;R:6;}}`},
+		{func() ([]byte, error) { return Set(refs, `e.f`, RawMessage(`a:1:{i:0;r:1;}`)) }, `a:4:{s:1:"a";a:1:{i:0;i:1;}s:1:"b";O:1:"X":0:{}s:1:"c";R:4;s:1:"e";a:1:{s:1:"f";a:1:{i:0;r:6;}}}`},
+	} {
+		b, err := tt.edit()
+		if assert.Nil(t, err) {
+			assert.Equal(t, tt.expected, string(b))
+			assert.Nil(t, Validate(b))
+		}
+	}
+	_, err = Delete(refs, `b`)
+	assert.EqualError(t, err, `phpserialize: Delete(reference at offset 55 points into the edited value)`)
+	_, err = Set(refs, `b`, 1)
+	assert.EqualError(t, err, `phpserialize: Set(reference at offset 55 points into the edited value)`)
+}
+
+func TestRepair(t *testing.T) {
+	for _, tt := range []struct {
+		input, expected string
+	}{
+		{`s:5:"Hello";`, `s:5:"Hello";`},
+		{`s:3:"Héllo";`, `s:6:"Héllo";`},
+		{`s:20:"short";`, `s:5:"short";`},
+		{`a:2:{s:3:"url";s:15:"https://example.org";s:4:"note";s:2:"a";b";}`, `a:2:{s:3:"url";s:19:"https://example.org";s:4:"note";s:4:"a";b";}`},
+		{`a:1:{s:1:"x";s:1:"ab";}b:1;`, `a:1:{s:1:"x";s:2:"ab";}b:1;`},
+		{`O:3:"Foo":1:{s:5:"title";s:1:"Ünïcode";}`, `O:3:"Foo":1:{s:5:"title";s:9:"Ünïcode";}`},
+		// serialized strings within strings end where the rest still parses
+		{`s:3:"a:1:{s:1:"k";i:1;}";`, `s:18:"a:1:{s:1:"k";i:1;}";`},
+		{`a:2:{s:4:"opts";s:5:"a:1:{s:1:"k";s:1:"v";}";s:1:"n";i:1;}`, `a:2:{s:4:"opts";s:22:"a:1:{s:1:"k";s:1:"v";}";s:1:"n";i:1;}`},
+		{`a:1:{i:0;s:1:"s:1:"é";";}`, `a:1:{i:0;s:9:"s:1:"é";";}`},
+	} {
+		b, err := Repair([]byte(tt.input))
+		assert.Nil(t, err, tt.input)
+		assert.Equal(t, tt.expected, string(b), tt.input)
+	}
+
+	_, err := Repair([]byte(`s:3:"abc`))
+	assert.EqualError(t, err, `phpserialize: Decode(unterminated string)`)
+
+	var v map[string]string
+	b, err := UnmarshalRepair([]byte(`a:1:{s:4:"city";s:5:"Zürich";}`), &v)
+	assert.Nil(t, err)
+	assert.Equal(t, `a:1:{s:4:"city";s:7:"Zürich";}`, string(b))
+	assert.Equal(t, map[string]string{`city`: `Zürich`}, v)
+
+	// Each wrongly guessed end costs one retry, which fails within the
+	// value, so many broken nested payloads are repaired in linear time.
+	var broken, repaired strings.Builder
+	fmt.Fprintf(&broken, `a:%d:{`, 500)
+	fmt.Fprintf(&repaired, `a:%d:{`, 500)
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&broken, `i:%d;s:1:"a:1:{s:1:"k";s:1:"v";}";`, i)
+		fmt.Fprintf(&repaired, `i:%d;s:22:"a:1:{s:1:"k";s:1:"v";}";`, i)
+	}
+	broken.WriteString(`}`)
+	repaired.WriteString(`}`)
+	r := repairer{data: []byte(broken.String())}
+	b, err = r.run()
+	assert.Nil(t, err)
+	assert.Equal(t, repaired.String(), string(b))
+	assert.Equal(t, 2*500, r.retries)
+}
+
+func TestReplace(t *testing.T) {
+	nested := `a:1:{s:4:"home";s:14:"http://old.com";}`
+	data := []byte(`a:3:{s:4:"site";s:14:"http://old.com";s:14:"http://old.com";i:1;s:6:"widget";s:` +
+		fmt.Sprint(len(nested)) + `:"` + nested + `";}`)
+
+	b, err := Replace(data, `http://old.com`, `https://new.example`, ReplaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, `a:3:{s:4:"site";s:19:"https://new.example";s:14:"http://old.com";i:1;s:6:"widget";s:44:"a:1:{s:4:"home";s:19:"https://new.example";}";}`, string(b))
+
+	b, err = Replace(data, `http://old.com`, `https://new.example`, ReplaceOptions{Keys: true})
+	assert.Nil(t, err)
+	var v map[string]interface{}
+	assert.Nil(t, Unmarshal(b, &v))
+	assert.Equal(t, int64(1), v[`https://new.example`])
+
+	b, err = Replace([]byte(`i:1;`), `1`, `2`, ReplaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, `i:1;`, string(b))
+
+	_, err = Replace([]byte(`s:9:"old";`), `old`, `new`, ReplaceOptions{})
+	assert.EqualError(t, err, `phpserialize: Decode(string of length 9 exceeds input)`)
+}
+
+func TestIsSerialized(t *testing.T) {
+	for data, expected := range map[string]bool{
+		`N;`:                  true,
+		" b:0; \n":            true,
+		`i:-12;`:              true,
+		`d:1.5E+25;`:          true,
+		`s:5:"Hello";`:        true,
+		`a:0:{}`:              true,
+		`O:8:"stdClass":0:{}`: true,
+		`E:7:"Foo:Bar";`:      true,
+		`s:5:"Hello"`:         false,
+		`s:5:Hello;`:          false,
+		`i:x;`:                false,
+		`a:b:{}`:              false,
+		`hello`:               false,
+		`b:;`:                 false,
+		`x:1;`:                false,
+		`N`:                   false,
+	} {
+		assert.Equal(t, expected, IsSerialized([]byte(data)), data)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	inner := `a:1:{s:5:"theme";s:4:"dark";}`
+	twice := fmt.Sprintf(`s:%d:"%s";`, len(inner), inner)
+	data := fmt.Sprintf(`a:2:{s:7:"options";s:%d:"%s";s:4:"name";s:3:"a:b";}`, len(twice), twice)
+
+	b, err := Unwrap([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, `a:2:{s:7:"options";a:1:{s:5:"theme";s:4:"dark";}s:4:"name";s:3:"a:b";}`, string(b))
+
+	var v struct {
+		Options map[string]string `php:"options"`
+		Name    string            `php:"name"`
+	}
+	assert.Nil(t, UnmarshalUnwrap([]byte(data), &v))
+	assert.Equal(t, map[string]string{`theme`: `dark`}, v.Options)
+	assert.Equal(t, `a:b`, v.Name)
+
+	// references keep pointing at the same values
+	for input, expected := range map[string]string{
+		`a:3:{i:0;s:14:"a:1:{i:0;i:5;}";i:1;O:1:"A":0:{}i:2;r:3;}`: `a:3:{i:0;a:1:{i:0;i:5;}i:1;O:1:"A":0:{}i:2;r:4;}`,
+		`a:2:{i:0;i:7;i:1;s:22:"a:2:{i:0;i:5;i:1;R:2;}";}`:         `a:2:{i:0;i:7;i:1;a:2:{i:0;i:5;i:1;R:4;}}`,
+		`a:3:{i:0;s:4:"b:1;";i:1;R:2;i:2;s:5:"i:42;";}`:            `a:3:{i:0;b:1;i:1;R:2;i:2;i:42;}`,
+	} {
+		b, err := Unwrap([]byte(input))
+		assert.Nil(t, err, input)
+		assert.Equal(t, expected, string(b), input)
+		assert.True(t, Valid(b), input)
+	}
+	_, err = Unwrap([]byte(`a:1:{i:0;r:5;}`))
+	assert.EqualError(t, err, `phpserialize: Decode(reference 5 out of range)`)
+}
+
+func TestValidate(t *testing.T) {
+	for _, data := range []string{
+		`N;`,
+		`b:1;`,
+		`i:-9223372036854775808;`,
+		`d:.5;`,
+		`d:1.;`,
+		`d:-1.5E+25;`,
+		`d:-INF;`,
+		`s:5:"a";b;";`,
+		`a:2:{i:0;s:1:"a";s:1:"b";a:0:{}}`,
+		`O:8:"App\Item":1:{s:4:"self";r:1;}`,
+		`a:2:{i:0;i:1;i:1;R:2;}`,
+		`a:3:{i:0;O:1:"A":0:{}i:1;r:2;i:2;r:3;}`,
+		`C:11:"ArrayObject":3:{x:y}`,
+	} {
+		assert.Nil(t, Validate([]byte(data)), data)
+		assert.True(t, Valid([]byte(data)), data)
+	}
+
+	for data, msg := range map[string]string{
+		``:                          `phpserialize: Decode(unexpected end of input)`,
+		`i:1;i:2;`:                  `phpserialize: Decode(unexpected data after value)`,
+		`i:9223372036854775808;`:    `phpserialize: Decode(integer out of range)`,
+		`b:2;`:                      `phpserialize: Decode(invalid bool)`,
+		`d:.;`:                      `phpserialize: Decode(expected digit)`,
+		`s:6:"a";`:                  `phpserialize: Decode(string length exceeds input)`,
+		`s:1:"ab";`:                 `phpserialize: Decode(expected byte '"' found 'b')`,
+		`a:2:{i:0;i:1;}`:            `phpserialize: Decode(invalid array key)`,
+		`a:1:{d:0;i:1;}`:            `phpserialize: Decode(invalid array key)`,
+		`a:1:{i:0;R:3;}`:            `phpserialize: Decode(reference 3 out of range)`,
+		`a:1:{i:0;r:2;}`:            `phpserialize: Decode(reference 2 out of range)`,
+		`a:2:{i:0;R:1;i:1;R:3;}`:    `phpserialize: Decode(reference 3 out of range)`,
+		`O:3:"a-b":0:{}`:            `phpserialize: Decode(invalid class name)`,
+		`x:1;`:                      `phpserialize: Decode(unexpected code)`,
+		`a:99999999999999999999:{}`: `phpserialize: Decode(number out of range)`,
+	} {
+		assert.EqualError(t, Validate([]byte(data)), msg, data)
+		assert.False(t, Valid([]byte(data)), data)
+	}
+
+	data := []byte(`a:2:{s:4:"name";s:3:"Ann";s:4:"tags";a:2:{i:0;d:0.5;i:1;O:3:"Foo":1:{s:1:"a";N;}}}`)
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() { Valid(data) }))
+}
+
+func TestDecoder_Stream(t *testing.T) {
+	assert.EqualError(t, UnmarshalString(`i:1;garbage`, new(int)), `phpserialize: Decode(unexpected data after top-level value)`)
+	assert.EqualError(t, Unmarshal([]byte(`i:1;i:2;`), new(int)), `phpserialize: Decode(unexpected data after top-level value)`)
+
+	d := NewDecoder(io.MultiReader(strings.NewReader(`i:1;s:1:"a";`), strings.NewReader(`a:1:{i:0;b:1;}`)))
+	var values []interface{}
+	var offsets []int64
+	for d.More() {
+		offsets = append(offsets, d.InputOffset())
+		v, err := d.DecodeInterface()
+		assert.Nil(t, err)
+		values = append(values, v)
+	}
+	assert.Equal(t, []interface{}{int64(1), `a`, []interface{}{true}}, values)
+	assert.Equal(t, []int64{0, 4, 12}, offsets)
+	assert.Equal(t, int64(26), d.InputOffset())
+
+	d = NewDecoder(io.MultiReader(strings.NewReader(`i:1;rest`)))
+	assert.Nil(t, d.Decode(new(int)))
+	rest, _ := ioutil.ReadAll(d.Buffered())
+	assert.Equal(t, `rest`, string(rest))
+
+	d = NewDecoderBytes([]byte(`i:1;rest`))
+	assert.Nil(t, d.Decode(new(int)))
+	rest, _ = ioutil.ReadAll(d.Buffered())
+	assert.Equal(t, `rest`, string(rest))
+}
+
+func TestDecoder_Reset(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`a:1:{i:0;a:0:{}}`))
+	d.SetMaxDepth(1)
+	d.SetMaxElements(2)
+	assert.EqualError(t, d.Decode(new(interface{})), `phpserialize: Decode(input exceeds max depth of 1)`)
+
+	// Options survive, the position and depth of the failed decode do not.
+	d.Reset(strings.NewReader(`a:1:{i:0;i:5;}`))
+	assert.Equal(t, int64(0), d.InputOffset())
+	var v []int
+	assert.Nil(t, d.Decode(&v))
+	assert.Equal(t, []int{5}, v)
+
+	d.Reset(strings.NewReader(`a:3:{i:0;i:1;i:1;i:2;i:2;i:3;}`))
+	assert.EqualError(t, d.Decode(&v), `phpserialize: Decode(input exceeds max elements of 2)`)
+
+	d.ResetBytes([]byte(`s:2:"ok";`))
+	s, err := d.DecodeString()
+	assert.Nil(t, err)
+	assert.Equal(t, `ok`, s)
+	assert.False(t, d.More())
+
+	d.Reset(bytes.NewReader([]byte(`i:7;`)))
+	n, err := d.DecodeInt()
+	assert.Nil(t, err)
+	assert.Equal(t, 7, n)
+}
+
+func TestNewDecoderBytes(t *testing.T) {
+	type Item struct {
+		Name string            `php:"name"`
+		Tags map[string]string `php:"tags"`
+		Raw  RawMessage        `php:"raw"`
+	}
+	data := []byte(`a:3:{s:4:"name";s:5:"Hello";s:4:"tags";a:1:{s:1:"k";s:1:"v";}s:3:"raw";a:1:{i:0;s:1:"x";}}`)
+
+	var fromReader, fromBytes Item
+	assert.Nil(t, NewDecoder(bytes.NewReader(data)).Decode(&fromReader))
+	assert.Nil(t, NewDecoderBytes(data).Decode(&fromBytes))
+	assert.Equal(t, fromReader, fromBytes)
+
+	d := NewDecoderBytes([]byte(`s:5:"Hello";`))
+	d.SetMaxBytes(8)
+	assert.EqualError(t, d.Decode(new(string)), `phpserialize: Decode(input exceeds max bytes of 8)`)
+
+	_, err := NewDecoderBytes([]byte(`s:5:"Hel`)).DecodeString()
+	assert.Equal(t, io.EOF, err)
+
+	input := []byte(`s:5:"Hello";`)
+	d = NewDecoderBytes(input)
+	d.UseUnsafeStrings(true)
+	s, err := d.DecodeString()
+	assert.Nil(t, err)
+	assert.Equal(t, `Hello`, s)
+	input[5] = 'J'
+	assert.Equal(t, `Jello`, s)
+}
+
+func benchmarkIntArray(n int) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `a:%d:{`, n)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `i:%d;i:%d;`, i, i*7919-500000)
+	}
+	b.WriteString(`}`)
+	return b.Bytes()
+}
+
+func BenchmarkRepair(b *testing.B) {
+	var data strings.Builder
+	fmt.Fprintf(&data, `a:%d:{`, 1000)
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&data, `i:%d;s:1:"a:1:{s:1:"k";s:1:"v";}";`, i)
+	}
+	data.WriteString(`}`)
+	input := []byte(data.String())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Repair(input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalIntSlice(b *testing.B) {
+	data := benchmarkIntArray(1000)
+	v := make([]int64, 0, 1000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		v = v[:0]
+		if err := Unmarshal(data, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoderIntSlice(b *testing.B) {
+	data := benchmarkIntArray(1000)
+	v := make([]int64, 0, 1000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		v = v[:0]
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoderReset(b *testing.B) {
+	data := benchmarkIntArray(1000)
+	v := make([]int64, 0, 1000)
+	r := bytes.NewReader(data)
+	d := NewDecoder(r)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		v = v[:0]
+		r.Reset(data)
+		d.Reset(r)
+		if err := d.Decode(&v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalFloatSlice(b *testing.B) {
+	var buf bytes.Buffer
+	buf.WriteString(`a:1000:{`)
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, `i:%d;d:%d.25;`, i, i)
+	}
+	buf.WriteString(`}`)
+	data := buf.Bytes()
+	v := make([]float64, 0, 1000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		v = v[:0]
+		if err := Unmarshal(data, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeIntBounds(t *testing.T) {
+	var i64 int64
+	assert.Nil(t, UnmarshalString(`i:-9223372036854775808;`, &i64))
+	assert.Equal(t, int64(math.MinInt64), i64)
+	assert.Nil(t, UnmarshalString(`i:+9223372036854775807;`, &i64))
+	assert.Equal(t, int64(math.MaxInt64), i64)
+	assert.EqualError(t, UnmarshalString(`i:9223372036854775808;`, &i64), `phpserialize: cannot unmarshal integer 9223372036854775808 into Go value of type int64`)
+	assert.EqualError(t, UnmarshalString(`i:1-2;`, &i64), `phpserialize: Decode(invalid integer "1-2")`)
+
+	var v struct {
+		U64 uint64 `php:"u"`
+		I8  int8   `php:"i"`
+	}
+	assert.Nil(t, UnmarshalString(`a:2:{s:1:"u";i:18446744073709551615;s:1:"i";i:-128;}`, &v))
+	assert.Equal(t, uint64(math.MaxUint64), v.U64)
+	assert.Equal(t, int8(-128), v.I8)
+	assert.EqualError(t, UnmarshalString(`a:1:{s:1:"i";i:-129;}`, &v), `phpserialize: cannot unmarshal integer -129 into Go field I8 of type int8`)
+}
+
+func TestCanonicalize(t *testing.T) {
+	for _, c := range []struct {
+		in, out string
+	}{
+		{`i:+5;`, `i:5;`},
+		{`d:1.50;`, `d:1.5;`},
+		{`a:3:{s:1:"b";d:1.50;i:1;i:+2;s:1:"0";s:1:"x";}`, `a:3:{i:0;s:1:"x";i:1;i:2;s:1:"b";d:1.5;}`},
+		{`a:3:{s:2:"10";i:1;s:2:"01";i:2;s:1:"9";i:3;}`, `a:3:{s:2:"01";i:2;i:9;i:3;i:10;i:1;}`},
+		{`a:2:{i:1;s:1:"a";s:1:"1";s:1:"b";}`, `a:1:{i:1;s:1:"b";}`},
+		{`O:1:"A":2:{s:1:"b";i:1;i:0;i:2;}`, `O:1:"A":2:{s:1:"0";i:2;s:1:"b";i:1;}`},
+		{`a:3:{s:1:"c";i:5;s:1:"a";i:7;s:1:"b";R:3;}`, `a:3:{s:1:"a";i:7;s:1:"b";R:2;s:1:"c";i:5;}`},
+		{`C:1:"A":3:{b;a}`, `C:1:"A":3:{b;a}`},
+	} {
+		out, err := Canonicalize([]byte(c.in))
+		if assert.Nil(t, err, c.in) {
+			assert.Equal(t, c.out, string(out), c.in)
+		}
+	}
+
+	_, err := Canonicalize([]byte(`a:2:{s:1:"b";a:0:{}s:1:"a";r:2;}`))
+	assert.EqualError(t, err, `phpserialize: Canonicalize(reference to a value that is no longer before it)`)
+	_, err = Canonicalize([]byte(`a:1:{i:0;r:5;}`))
+	assert.EqualError(t, err, `phpserialize: Decode(reference 5 out of range)`)
+}
+
+func TestEqual(t *testing.T) {
+	eq, err := Equal([]byte(`a:2:{i:0;d:1.0;i:1;s:1:"x";}`), []byte(`a:2:{i:1;s:1:"x";s:1:"0";d:1;}`))
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	eq, err = Equal([]byte(`a:1:{i:0;i:1;}`), []byte(`a:1:{i:0;s:1:"1";}`))
+	assert.Nil(t, err)
+	assert.False(t, eq)
+
+	_, err = Equal([]byte(`i:1;`), []byte(`i:1`))
+	assert.NotNil(t, err)
+
+	// References compare by what they point at, even where sorting the keys
+	// would put them before their target.
+	for _, tt := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{`a:2:{s:1:"b";a:0:{}s:1:"a";r:2;}`, `a:2:{s:1:"b";a:0:{}s:1:"a";r:2;}`, true},
+		{`a:2:{s:1:"b";a:0:{}s:1:"a";r:2;}`, `a:3:{s:1:"a";a:0:{}s:1:"b";a:0:{}s:1:"a";r:3;}`, true},
+		{`a:3:{s:1:"x";a:0:{}s:1:"y";a:0:{}s:1:"z";R:2;}`, `a:3:{s:1:"x";a:0:{}s:1:"y";a:0:{}s:1:"z";R:3;}`, false},
+		{`a:2:{i:0;a:0:{}i:1;R:2;}`, `a:2:{i:0;a:0:{}i:1;r:2;}`, false},
+		{`a:2:{i:0;a:0:{}i:1;R:2;}`, `a:2:{i:0;a:0:{}i:1;a:0:{}}`, false},
+		{`a:2:{i:0;s:1:"x";i:0;O:1:"A":0:{}}`, `a:1:{i:0;O:1:"A":0:{}}`, true},
+		{`O:1:"A":1:{i:0;i:1;}`, `O:1:"A":1:{s:1:"0";i:1;}`, true},
+		{`O:1:"A":0:{}`, `O:1:"B":0:{}`, false},
+		{`d:NAN;`, `d:NAN;`, true},
+	} {
+		eq, err := Equal([]byte(tt.a), []byte(tt.b))
+		assert.Nil(t, err, tt.a)
+		assert.Equal(t, tt.equal, eq, `%s %s`, tt.a, tt.b)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	assert.Nil(t, Diff([]byte(`a:2:{i:0;i:+1;s:1:"x";d:1.50;}`), []byte(`a:2:{s:1:"x";d:1.5;s:1:"0";i:1;}`)))
+
+	assert.Equal(t, []Change{
+		{Path: `db.host`, Old: RawMessage(`s:9:"localhost";`), New: RawMessage(`s:7:"db.prod";`)},
+		{Path: `db.port`, Old: RawMessage(`i:3306;`), New: nil},
+		{Path: `db.user`, Old: nil, New: RawMessage(`s:4:"root";`)},
+		{Path: `flags.1`, Old: RawMessage(`b:0;`), New: RawMessage(`b:1;`)},
+	}, Diff(
+		[]byte(`a:2:{s:2:"db";a:2:{s:4:"host";s:9:"localhost";s:4:"port";i:3306;}s:5:"flags";a:2:{i:0;b:1;i:1;b:0;}}`),
+		[]byte(`a:2:{s:5:"flags";a:2:{i:0;b:1;i:1;b:1;}s:2:"db";a:2:{s:4:"host";s:7:"db.prod";s:4:"user";s:4:"root";}}`),
+	))
+
+	// Properties keep their visibility mangling, so a protected and a public
+	// property of the same name are told apart, and the path works with Get.
+	before := []byte("O:1:\"A\":2:{s:4:\"\x00*\x00n\";i:1;s:1:\"n\";i:2;}")
+	after := []byte("O:1:\"A\":2:{s:4:\"\x00*\x00n\";i:3;s:1:\"n\";i:4;}")
+	changes := Diff(before, after)
+	assert.Equal(t, []Change{
+		{Path: "\x00\\*\x00n", Old: RawMessage(`i:1;`), New: RawMessage(`i:3;`)},
+		{Path: `n`, Old: RawMessage(`i:2;`), New: RawMessage(`i:4;`)},
+	}, changes)
+	for _, c := range changes {
+		r, err := Get(after, c.Path)
+		assert.NoError(t, err)
+		assert.Equal(t, c.New, r.Raw)
+	}
+	assert.Equal(t, []Change{
+		{Path: "\x00A\x00name", Old: RawMessage(`s:1:"a";`), New: RawMessage(`s:1:"b";`)},
+	}, Diff([]byte("O:1:\"A\":1:{s:7:\"\x00A\x00name\";s:1:\"a\";}"), []byte("O:1:\"A\":1:{s:7:\"\x00A\x00name\";s:1:\"b\";}")))
+	assert.Equal(t, []Change{
+		{Path: ``, Old: RawMessage(`O:1:"A":0:{}`), New: RawMessage(`O:1:"B":0:{}`)},
+	}, Diff([]byte(`O:1:"A":0:{}`), []byte(`O:1:"B":0:{}`)))
+
+	// Serialized strings are compared by their contents.
+	assert.Equal(t, []Change{
+		{Path: `opt.a\.b`, Old: RawMessage(`i:1;`), New: RawMessage(`i:2;`)},
+	}, Diff([]byte(`a:1:{s:3:"opt";s:20:"a:1:{s:3:"a.b";i:1;}";}`), []byte(`a:1:{s:3:"opt";s:20:"a:1:{s:3:"a.b";i:2;}";}`)))
+
+	// References are compared by the value they point at, not by slot.
+	assert.Equal(t, []Change{
+		{Path: `9`, Old: nil, New: RawMessage(`i:1;`)},
+	}, Diff([]byte(`a:2:{i:0;s:1:"x";i:1;r:2;}`), []byte(`a:3:{i:9;i:1;i:0;s:1:"x";i:1;r:3;}`)))
+	assert.Equal(t, []Change{
+		{Path: `2`, Old: RawMessage(`r:2;`), New: RawMessage(`r:3;`)},
+	}, Diff([]byte(`a:3:{i:0;s:1:"x";i:1;s:1:"x";i:2;r:2;}`), []byte(`a:3:{i:0;s:1:"x";i:1;s:1:"x";i:2;r:3;}`)))
+
+	assert.Equal(t, []Change{
+		{Path: ``, Old: RawMessage(`i:1`), New: RawMessage(`i:1;`)},
+	}, Diff([]byte(`i:1`), []byte(`i:1;`)))
+	assert.Nil(t, Diff([]byte(`x`), []byte(`x`)))
 }
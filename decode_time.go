@@ -0,0 +1,321 @@
+package phpserialize
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf((*time.Time)(nil)).Elem()
+
+const (
+	phpDateLayout     = `2006-01-02 15:04:05`
+	phpDateTimeLayout = `2006-01-02 15:04:05.000000`
+)
+
+// timezoneAbbreviations maps the abbreviations PHP stores with
+// timezone_type 2 to their UTC offset in seconds.
+var timezoneAbbreviations = map[string]int{
+	`ACDT`: 10*3600 + 1800,
+	`ACST`: 9*3600 + 1800,
+	`AEDT`: 11 * 3600,
+	`AEST`: 10 * 3600,
+	`AKDT`: -8 * 3600,
+	`AKST`: -9 * 3600,
+	`AWST`: 8 * 3600,
+	`BST`:  1 * 3600,
+	`CDT`:  -5 * 3600,
+	`CEST`: 2 * 3600,
+	`CET`:  1 * 3600,
+	`CST`:  -6 * 3600,
+	`EDT`:  -4 * 3600,
+	`EEST`: 3 * 3600,
+	`EET`:  2 * 3600,
+	`EST`:  -5 * 3600,
+	`HST`:  -10 * 3600,
+	`JST`:  9 * 3600,
+	`KST`:  9 * 3600,
+	`MDT`:  -6 * 3600,
+	`MSK`:  3 * 3600,
+	`MST`:  -7 * 3600,
+	`NZDT`: 13 * 3600,
+	`NZST`: 12 * 3600,
+	`PDT`:  -7 * 3600,
+	`PST`:  -8 * 3600,
+	`WEST`: 1 * 3600,
+	`WET`:  0,
+}
+
+func decodeTimeValue(d *Decoder, v reflect.Value) error {
+	t, err := d.DecodeTime()
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// DecodeTime decodes a time from any of the representations the Encoder
+// can produce: a unix timestamp, a Y-m-d H:i:s string or a serialized
+// DateTime / DateTimeImmutable object. Timestamps and strings carry no zone
+// and are returned in the location set by SetTimeLocation.
+func (d *Decoder) DecodeTime() (time.Time, error) {
+	offset := d.offset
+	code, err := d.PeekCode()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	switch code {
+	case 'N':
+		return time.Time{}, d.DecodeNil()
+	case 'i':
+		n, err := d.DecodeInt64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(n, 0).In(d.timeLocation()), nil
+	case 'd':
+		f, err := d.DecodeFloat64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e6))*1e3).In(d.timeLocation()), nil
+	case 's':
+		s, err := d.DecodeString()
+		if err != nil {
+			return time.Time{}, err
+		}
+		t, err := time.ParseInLocation(phpDateLayout, s, d.timeLocation())
+		if err != nil {
+			return time.Time{}, &UnmarshalTypeError{Value: fmt.Sprintf(`string %q`, s), Type: timeType, Offset: offset}
+		}
+		return t, nil
+	case 'O':
+		return d.decodeDateTimeObject()
+	}
+
+	return time.Time{}, &UnmarshalTypeError{Value: phpValueName(code), Type: timeType, Offset: offset}
+}
+
+/**
+  O:8:"DateTime":3:{s:4:"date";s:26:"2021-05-01 12:00:00.000000";s:13:"timezone_type";i:3;s:8:"timezone";s:3:"UTC";}
+*/
+func (d *Decoder) decodeDateTimeObject() (time.Time, error) {
+	// The class name is not checked and unknown properties are skipped, so
+	// DateTime subclasses such as Carbon\Carbon decode as well.
+	offset := d.offset
+	_, n, err := d.DecodeObjectLen()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var (
+		date     string
+		zone     string
+		zoneType int
+	)
+	for i := 0; i < n; i++ {
+		name, err := d.DecodeString()
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch name {
+		case `date`:
+			date, err = d.DecodeString()
+		case `timezone_type`:
+			zoneType, err = d.DecodeInt()
+		case `timezone`:
+			zone, err = d.DecodeString()
+		default:
+			err = d.Skip()
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	if err := d.DecodeArrayEnd(); err != nil {
+		return time.Time{}, err
+	}
+
+	loc, ok := phpTimezoneLocation(zoneType, zone)
+	if !ok {
+		return time.Time{}, &UnmarshalTypeError{Value: fmt.Sprintf(`timezone %q of type %d`, zone, zoneType), Type: timeType, Offset: offset}
+	}
+	t, err := time.ParseInLocation(phpDateLayout, date, loc)
+	if err != nil {
+		return time.Time{}, &UnmarshalTypeError{Value: fmt.Sprintf(`date %q`, date), Type: timeType, Offset: offset}
+	}
+	return t, nil
+}
+
+func phpTimezoneLocation(zoneType int, zone string) (*time.Location, bool) {
+	switch zoneType {
+	case 1:
+		if offset, ok := parseTimezoneOffset(zone); ok {
+			return time.FixedZone(zone, offset), true
+		}
+	case 2:
+		if offset, ok := timezoneAbbreviations[strings.ToUpper(zone)]; ok {
+			return time.FixedZone(zone, offset), true
+		}
+	case 3:
+		if zone == `UTC` {
+			return time.UTC, true
+		}
+		if loc, err := time.LoadLocation(zone); err == nil {
+			return loc, true
+		}
+	}
+	return nil, false
+}
+
+// parseTimezoneOffset parses offsets in the +05:00 form PHP uses for
+// timezone_type 1.
+func parseTimezoneOffset(zone string) (int, bool) {
+	if len(zone) != 6 || (zone[0] != '+' && zone[0] != '-') || zone[3] != ':' {
+		return 0, false
+	}
+	hours, err := strconv.Atoi(zone[1:3])
+	if err != nil {
+		return 0, false
+	}
+	minutes, err := strconv.Atoi(zone[4:6])
+	if err != nil {
+		return 0, false
+	}
+	offset := hours*3600 + minutes*60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	return offset, true
+}
+
+func (d *Decoder) timeLocation() *time.Location {
+	if d.loc == nil {
+		return time.UTC
+	}
+	return d.loc
+}
+
+// durationSecondsDecoder returns the decoder for a time.Duration or
+// *time.Duration field tagged with the seconds option.
+func durationSecondsDecoder(typ reflect.Type) decoderFunc {
+	decode := func(d *Decoder, v reflect.Value) error {
+		dur, err := d.DecodeDurationSeconds()
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(dur))
+		return nil
+	}
+	if typ.Kind() == reflect.Ptr {
+		return ptrDecoderFuncWith(decode)
+	}
+	return decode
+}
+
+// DecodeDurationSeconds decodes an integer or float number of seconds, as
+// a field tagged with the seconds option is decoded.
+func (d *Decoder) DecodeDurationSeconds() (time.Duration, error) {
+	code, err := d.PeekCode()
+	if err != nil {
+		return 0, err
+	}
+	if code == 'd' {
+		f, err := d.DecodeFloat64()
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(math.Round(f * float64(time.Second))), nil
+	}
+	n, err := d.DecodeInt64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+func decodeDateIntervalValue(d *Decoder, v reflect.Value) error {
+	i, err := d.DecodeDateInterval()
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(i))
+	return nil
+}
+
+// DecodeDateInterval decodes a serialized PHP DateInterval object such as
+//
+//	O:12:"DateInterval":10:{s:1:"y";i:0;s:1:"m";i:0;s:1:"d";i:1;...}
+//
+// Properties only present in some PHP versions, such as weekday or
+// from_string, are ignored.
+func (d *Decoder) DecodeDateInterval() (DateInterval, error) {
+	var v DateInterval
+
+	offset := d.offset
+	class, n, err := d.DecodeObjectLen()
+	if err != nil {
+		return v, err
+	}
+	if class != `DateInterval` {
+		return v, &UnmarshalTypeError{Value: `object ` + class, Type: dateIntervalType, Offset: offset}
+	}
+
+	for i := 0; i < n; i++ {
+		name, err := d.DecodeString()
+		if err != nil {
+			return v, err
+		}
+		switch name {
+		case `y`:
+			v.Y, err = d.DecodeInt()
+		case `m`:
+			v.M, err = d.DecodeInt()
+		case `d`:
+			v.D, err = d.DecodeInt()
+		case `h`:
+			v.H, err = d.DecodeInt()
+		case `i`:
+			v.I, err = d.DecodeInt()
+		case `s`:
+			v.S, err = d.DecodeInt()
+		case `f`:
+			v.F, err = d.DecodeFloat64()
+		case `invert`:
+			var invert int
+			invert, err = d.DecodeInt()
+			v.Invert = invert != 0
+		case `days`:
+			v.Days, err = d.decodeIntervalDays()
+		default:
+			err = d.Skip()
+		}
+		if err != nil {
+			return v, err
+		}
+	}
+
+	return v, d.DecodeArrayEnd()
+}
+
+func (d *Decoder) decodeIntervalDays() (*int, error) {
+	code, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	if code == 'b' {
+		_, err := d.DecodeBool()
+		return nil, err
+	}
+	days, err := d.DecodeInt()
+	if err != nil {
+		return nil, err
+	}
+	return &days, nil
+}
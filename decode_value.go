@@ -1,13 +1,13 @@
 package phpserialize
 
 import (
-	"fmt"
 	"math/bits"
 	"reflect"
 )
 
 var (
-	stringType = reflect.TypeOf((*string)(nil)).Elem()
+	stringType    = reflect.TypeOf((*string)(nil)).Elem()
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
 )
 
 func getDecoder(typ reflect.Type) decoderFunc {
@@ -28,12 +28,24 @@ func _getDecoder(typ reflect.Type) decoderFunc {
 		}
 	}
 
-	//if typ.Implements(customDecoderType) {
-	//	return decodeCustomValue
-	//}
-	//if typ.Implements(unmarshalerType) {
-	//	return unmarshalValue
-	//}
+	if typ.Implements(customDecoderType) {
+		return decodeCustomValue
+	}
+	if typ.Implements(unmarshalerType) {
+		return unmarshalValue
+	}
+
+	// Addressable struct field value.
+	if kind != reflect.Ptr {
+		ptr := reflect.PtrTo(typ)
+		if ptr.Implements(customDecoderType) {
+			return decodeCustomValueAddr
+		}
+		if ptr.Implements(unmarshalerType) {
+			return unmarshalValueAddr
+		}
+	}
+
 	//if typ.Implements(binaryUnmarshalerType) {
 	//	return unmarshalBinaryValue
 	//}
@@ -44,12 +56,6 @@ func _getDecoder(typ reflect.Type) decoderFunc {
 	//// Addressable struct field value.
 	//if kind != reflect.Ptr {
 	//	ptr := reflect.PtrTo(typ)
-	//	if ptr.Implements(customDecoderType) {
-	//		return decodeCustomValueAddr
-	//	}
-	//	if ptr.Implements(unmarshalerType) {
-	//		return unmarshalValueAddr
-	//	}
 	//	if ptr.Implements(binaryUnmarshalerType) {
 	//		return unmarshalBinaryValueAddr
 	//	}
@@ -58,6 +64,19 @@ func _getDecoder(typ reflect.Type) decoderFunc {
 	//	}
 	//}
 
+	switch typ {
+	case timeType:
+		return decodeTimeValue
+	case dateIntervalType:
+		return decodeDateIntervalValue
+	case incompleteObjectType:
+		return decodeIncompleteObjectValue
+	case rawMessageType:
+		return decodeRawMessageValue
+	case treeType:
+		return decodeTreeValue
+	}
+
 	switch kind {
 	case reflect.Ptr:
 		return ptrDecoderFunc(typ)
@@ -88,7 +107,10 @@ func _getDecoder(typ reflect.Type) decoderFunc {
 }
 
 func ptrDecoderFunc(typ reflect.Type) decoderFunc {
-	decoder := getDecoder(typ.Elem())
+	return ptrDecoderFuncWith(getDecoder(typ.Elem()))
+}
+
+func ptrDecoderFuncWith(decoder decoderFunc) decoderFunc {
 	return func(d *Decoder, v reflect.Value) error {
 		if d.hasNilCode() {
 			if !v.IsNil() {
@@ -124,9 +146,9 @@ func init() {
 		reflect.Complex64:  decodeUnsupportedValue,
 		reflect.Complex128: decodeUnsupportedValue,
 		// reflect.Array:         decodeArrayValue,
-		reflect.Chan: decodeUnsupportedValue,
-		reflect.Func: decodeUnsupportedValue,
-		//reflect.Interface:     decodeInterfaceValue,
+		reflect.Chan:          decodeUnsupportedValue,
+		reflect.Func:          decodeUnsupportedValue,
+		reflect.Interface:     decodeInterfaceValue,
 		reflect.Map:           decodeMapValue,
 		reflect.Ptr:           decodeUnsupportedValue,
 		reflect.Slice:         decodeSliceValue,
@@ -145,8 +167,24 @@ func decodeStringValue(d *Decoder, v reflect.Value) error {
 	return nil
 }
 
+func decodeInterfaceValue(d *Decoder, v reflect.Value) error {
+	if v.NumMethod() != 0 {
+		return d.unsupportedTypeError(v.Type())
+	}
+	iface, err := d.DecodeInterface()
+	if err != nil {
+		return err
+	}
+	if iface == nil {
+		v.Set(reflect.Zero(v.Type()))
+	} else {
+		v.Set(reflect.ValueOf(iface))
+	}
+	return nil
+}
+
 func decodeUnsupportedValue(d *Decoder, v reflect.Value) error {
-	return fmt.Errorf("phpserialize: Decode(unsupported %s)", v.Type())
+	return d.unsupportedTypeError(v.Type())
 }
 
 func decodeBoolValue(d *Decoder, v reflect.Value) error {
@@ -181,8 +219,7 @@ func decodeInt64Value(d *Decoder, v reflect.Value) error {
 func decodeSignedIntValue(d *Decoder, v reflect.Value, bitSize int) error {
 	n, err := d.DecodeSignedInt(bitSize)
 	if err != nil {
-		// TODO: should this wrapErr so the prefix phpserialize is maintained?
-		return err
+		return withType(err, v.Type())
 	}
 	v.SetInt(n)
 	return nil
@@ -191,8 +228,7 @@ func decodeSignedIntValue(d *Decoder, v reflect.Value, bitSize int) error {
 func decodeFloat32Value(d *Decoder, v reflect.Value) error {
 	n, err := d.DecodeFloat(32)
 	if err != nil {
-		// TODO: should this wrapErr so the prefix phpserialize is maintained?
-		return err
+		return withType(err, v.Type())
 	}
 	v.SetFloat(n)
 	return nil
@@ -201,8 +237,7 @@ func decodeFloat32Value(d *Decoder, v reflect.Value) error {
 func decodeFloat64Value(d *Decoder, v reflect.Value) error {
 	n, err := d.DecodeFloat64()
 	if err != nil {
-		// TODO: should this wrapErr so the prefix phpserialize is maintained?
-		return err
+		return withType(err, v.Type())
 	}
 	v.SetFloat(n)
 	return nil
@@ -231,8 +266,7 @@ func decodeUint64Value(d *Decoder, v reflect.Value) error {
 func decodeUnsignedIntValue(d *Decoder, v reflect.Value, bitSize int) error {
 	n, err := d.DecodeUnsignedInt(bitSize)
 	if err != nil {
-		// TODO: should this wrapErr so the prefix phpserialize is maintained?
-		return err
+		return withType(err, v.Type())
 	}
 	v.SetUint(n)
 	return nil
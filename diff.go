@@ -0,0 +1,202 @@
+package phpserialize
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Change is a difference between two serialized values found by Diff.
+type Change struct {
+	// Path is the path of the value in the syntax Get takes, empty for the
+	// top-level value. Protected and private properties keep their
+	// visibility mangling, such as "\x00*\x00name", so they cannot be
+	// confused with a public property of the same name.
+	Path string
+	// Old and New hold the serialized values. Old is nil where a key was
+	// added and New is nil where a key was removed.
+	Old, New RawMessage
+}
+
+// Diff returns the differences between the serialized values a and b.
+// Arrays and objects of the same class are compared key by key, with
+// integer-like string keys matching integer keys as in PHP; a change in
+// kind or class is reported for the value as a whole. Strings that both
+// hold a serialized value are compared as such. Numbers are compared by
+// value, so i:+1; equals i:1; and d:1.50; equals d:1.5;, and references
+// by the path of the value they point at. Within an array or object,
+// changes follow the key order of a, then the keys only b has.
+//
+// Input that is not a single valid serialized value is compared byte for
+// byte as a whole.
+func Diff(a, b []byte) []Change {
+	var (
+		va, vb  Value
+		changes []Change
+	)
+	if Unmarshal(a, &va) != nil || Unmarshal(b, &vb) != nil || !diffTrees(``, &va, &vb, &changes) {
+		if bytes.Equal(a, b) {
+			return nil
+		}
+		return []Change{{Old: a, New: b}}
+	}
+	return changes
+}
+
+// differ compares two decoded values.
+type differ struct {
+	changes *[]Change
+	// refsA and refsB map the references of each value to their targets,
+	// and pathsA and pathsB the parts of each value to their paths.
+	refsA, refsB   map[*Value]*Value
+	pathsA, pathsB map[*Value]string
+}
+
+// diffTrees appends the differences between a and b, found at path, to
+// changes. It reports false when either holds a reference that is out of
+// range.
+func diffTrees(path string, a, b *Value, changes *[]Change) bool {
+	ca := canonicalizer{refs: make(map[*Value]*Value)}
+	cb := canonicalizer{refs: make(map[*Value]*Value)}
+	if ca.resolve(a) != nil || cb.resolve(b) != nil {
+		return false
+	}
+	d := differ{
+		changes: changes,
+		refsA:   ca.refs,
+		refsB:   cb.refs,
+		pathsA:  make(map[*Value]string),
+		pathsB:  make(map[*Value]string),
+	}
+	recordPaths(path, a, d.pathsA)
+	recordPaths(path, b, d.pathsB)
+	d.diff(path, a, b)
+	return true
+}
+
+func recordPaths(path string, v *Value, paths map[*Value]string) {
+	paths[v] = path
+	for i, elem := range v.elems {
+		recordPaths(appendPath(path, v.keys[i], v.code == 'O'), elem, paths)
+	}
+}
+
+func (d *differ) diff(path string, a, b *Value) {
+	if a.code == 's' && b.code == 's' && a.text != b.text {
+		var na, nb Value
+		if isSerializedSequence([]byte(a.text)) && isSerializedSequence([]byte(b.text)) &&
+			UnmarshalString(a.text, &na) == nil && UnmarshalString(b.text, &nb) == nil &&
+			diffTrees(path, &na, &nb, d.changes) {
+			return
+		}
+	}
+
+	if a.Kind() != b.Kind() || a.class != b.class {
+		d.change(path, a, b)
+		return
+	}
+	switch a.code {
+	case 'a', 'O':
+		d.diffElements(path, a, b)
+	case 'i':
+		if a.Int() != b.Int() {
+			d.change(path, a, b)
+		}
+	case 'd':
+		if fa, fb := a.Float(), b.Float(); fa != fb && !(math.IsNaN(fa) && math.IsNaN(fb)) {
+			d.change(path, a, b)
+		}
+	case 'r', 'R':
+		if a.code != b.code || d.pathsA[d.refsA[a]] != d.pathsB[d.refsB[b]] {
+			d.change(path, a, b)
+		}
+	default:
+		if a.code != b.code || a.text != b.text {
+			d.change(path, a, b)
+		}
+	}
+}
+
+func (d *differ) diffElements(path string, a, b *Value) {
+	object := a.code == 'O'
+	index := make(map[phpKey]int, len(b.keys))
+	for i := range b.keys {
+		index[elementKey(b.keys[i], object)] = i
+	}
+
+	seen := make(map[int]bool, len(a.keys))
+	for i := range a.keys {
+		keyPath := appendPath(path, a.keys[i], object)
+		j, ok := index[elementKey(a.keys[i], object)]
+		if !ok {
+			d.change(keyPath, a.elems[i], nil)
+			continue
+		}
+		seen[j] = true
+		d.diff(keyPath, a.elems[i], b.elems[j])
+	}
+	for j := range b.keys {
+		if !seen[j] {
+			d.change(appendPath(path, b.keys[j], object), nil, b.elems[j])
+		}
+	}
+}
+
+// change records that the value at path changed from before to after,
+// either of which is nil where a key was added or removed.
+func (d *differ) change(path string, before, after *Value) {
+	c := Change{Path: path}
+	if before != nil {
+		c.Old = rawTree(before)
+	}
+	if after != nil {
+		c.New = rawTree(after)
+	}
+	*d.changes = append(*d.changes, c)
+}
+
+// rawTree serializes part of a value with its references as they were
+// decoded, since what they point at may lie outside that part.
+func rawTree(v *Value) RawMessage {
+	var buf bytes.Buffer
+	t := treeEncoder{e: NewEncoder(&buf), verbatim: true}
+	t.encode(v)
+	return buf.Bytes()
+}
+
+// elementKey returns the key under which PHP stores key.
+func elementKey(key Value, object bool) phpKey {
+	if key.code == 'i' {
+		if object {
+			return phpKey{isStr: true, str: strconv.FormatInt(key.Int(), 10)}
+		}
+		return phpKey{num: key.Int()}
+	}
+	if n, ok := integerKey(key.text); ok && !object {
+		return phpKey{num: n}
+	}
+	return phpKey{isStr: true, str: key.text}
+}
+
+// appendPath appends key to path as a segment Get accepts.
+func appendPath(path string, key Value, object bool) string {
+	name := key.text
+	if key.code == 'i' {
+		name = strconv.FormatInt(key.Int(), 10)
+	}
+
+	var b strings.Builder
+	if path != `` {
+		b.WriteString(path)
+		b.WriteByte('.')
+	}
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '.', '*', '?', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
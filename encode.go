@@ -1,11 +1,14 @@
 package phpserialize
 
 import (
+	"bufio"
 	"bytes"
 	"io"
 	"math"
 	"reflect"
 	"strconv"
+	"sync"
+	"time"
 )
 
 type writer interface {
@@ -28,30 +31,110 @@ func (bw byteWriter) WriteByte(c byte) error {
 	return err
 }
 
+// appendWriter collects the output of Marshal and AppendMarshal.
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(b []byte) (int, error) {
+	w.b = append(w.b, b...)
+	return len(b), nil
+}
+
+func (w *appendWriter) WriteByte(c byte) error {
+	w.b = append(w.b, c)
+	return nil
+}
+
+func (w *appendWriter) WriteString(s string) (int, error) {
+	w.b = append(w.b, s...)
+	return len(s), nil
+}
+
+var encPool = sync.Pool{
+	New: func() interface{} {
+		return NewEncoder(nil)
+	},
+}
+
+// GetEncoder returns an Encoder with default options from a pool. Set its
+// writer with Reset and return it with PutEncoder once done.
+func GetEncoder() *Encoder {
+	return encPool.Get().(*Encoder)
+}
+
+// PutEncoder returns an Encoder obtained from GetEncoder to the pool. The
+// Encoder must not be used afterwards.
+func PutEncoder(enc *Encoder) {
+	enc.Reset(nil)
+	enc.flags = 0
+	enc.precision = 0
+	enc.timeFormat = TimeAsDateTime
+	encPool.Put(enc)
+}
+
 // Marshal returns the PHP Serialized encoding of v.
 func Marshal(v interface{}) ([]byte, error) {
-	//enc := GetEncoder()
-	enc := Encoder{}
-
-	var buf bytes.Buffer
-	//enc.Reset(&buf)
-	enc.resetWriter(&buf)
+	enc := GetEncoder()
+	enc.aw.b = enc.aw.b[:0]
+	enc.resetWriter(&enc.aw)
 
+	var b []byte
 	err := enc.Encode(v)
-	b := buf.Bytes()
+	if err == nil {
+		b = append([]byte(nil), enc.aw.b...)
+	}
+
+	if cap(enc.aw.b) > bytesAllocLimit {
+		enc.aw.b = nil
+	}
+	PutEncoder(enc)
+	return b, err
+}
 
-	// PutEncoder(enc)
+// AppendMarshal appends the PHP Serialized encoding of v to dst and returns
+// the extended buffer. Encoding allocates nothing beyond growing dst. On
+// error dst is returned unchanged.
+func AppendMarshal(dst []byte, v interface{}) ([]byte, error) {
+	enc := GetEncoder()
+	buf := enc.aw.b
+	enc.aw.b = dst
+	enc.resetWriter(&enc.aw)
 
+	err := enc.Encode(v)
+	b := enc.aw.b
+	enc.aw.b = buf
+	PutEncoder(enc)
 	if err != nil {
-		return nil, err
+		return dst, err
 	}
-	return b, err
+	return b, nil
 }
 
 type Encoder struct {
 	w writer
+	// sw is w when it can write strings without converting them.
+	sw io.StringWriter
+	// bw buffers writers without a WriteByte method for an Encoder created
+	// by NewBufferedEncoder. It is flushed after each call to Encode.
+	bw *bufio.Writer
+	// aw is the reusable output buffer of Marshal.
+	aw appendWriter
+	// scratch holds numbers and byte sequences while they are written.
+	scratch [32]byte
+
+	flags      uint32
+	timeFormat TimeFormat
+	// precision is the serialize_precision set by SetSerializePrecision.
+	precision int
 }
 
+const (
+	unsortedMapKeysFlag uint32 = 1 << iota
+	serializePrecisionFlag
+	bufferedFlag
+)
+
 // NewEncoder returns a new encoder that writes to w.
 func NewEncoder(w io.Writer) *Encoder {
 	e := &Encoder{}
@@ -59,15 +142,66 @@ func NewEncoder(w io.Writer) *Encoder {
 	return e
 }
 
+// NewBufferedEncoder returns a new encoder that writes to w. Unlike with
+// NewEncoder, writers without a WriteByte method, such as files and
+// network connections, are buffered and written to once per call to
+// Encode, so write errors only surface once a value is complete. Output of
+// the other Encode methods reaches them after a call to Flush.
+func NewBufferedEncoder(w io.Writer) *Encoder {
+	e := &Encoder{flags: bufferedFlag}
+	e.resetWriter(w)
+	return e
+}
+
+// Reset makes the Encoder write to w, keeping its options and whether it
+// buffers.
+func (e *Encoder) Reset(w io.Writer) {
+	e.resetWriter(w)
+}
+
 func (e *Encoder) resetWriter(w io.Writer) {
-	if bw, ok := w.(writer); ok {
-		e.w = bw
-	} else {
-		e.w = newByteWriter(w)
+	switch w := w.(type) {
+	case nil:
+		e.w = nil
+	case writer:
+		e.w = w
+	default:
+		if e.flags&bufferedFlag == 0 {
+			e.w = newByteWriter(w)
+			break
+		}
+		if e.bw == nil {
+			e.bw = bufio.NewWriter(w)
+		} else {
+			e.bw.Reset(w)
+		}
+		e.w = e.bw
 	}
+	if e.w != e.bw && e.bw != nil {
+		e.bw.Reset(nil)
+	}
+	e.sw, _ = e.w.(io.StringWriter)
 }
 
+// Flush writes any buffered output to the underlying writer. Encode does
+// so itself, so Flush is only needed after the other Encode methods.
+func (e *Encoder) Flush() error {
+	if e.w != nil && e.w == e.bw {
+		return e.bw.Flush()
+	}
+	return nil
+}
+
+// Encode writes the PHP Serialized encoding of v.
 func (e *Encoder) Encode(v interface{}) error {
+	err := e.encode(v)
+	if flushErr := e.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func (e *Encoder) encode(v interface{}) error {
 	switch v := v.(type) {
 	case nil:
 		return e.EncodeNil()
@@ -95,11 +229,10 @@ func (e *Encoder) Encode(v interface{}) error {
 		return e.EncodeFloat64(float64(v))
 	case float64:
 		return e.EncodeFloat64(v)
-		/*case time.Duration:
-			return e.encodeInt64Cond(int64(v))
-		case time.Time:
-			return e.EncodeTime(v)
-		*/
+	case time.Duration:
+		return e.EncodeInt64(int64(v))
+	case time.Time:
+		return e.EncodeTime(v)
 	}
 
 	return e.EncodeValue(reflect.ValueOf(v))
@@ -115,7 +248,19 @@ func (e *Encoder) EncodeNil() error {
 }
 
 func (e *Encoder) EncodeString(v string) error {
-	return e.EncodeBytes([]byte(v))
+	if err := e.writeBytes('s', ':'); err != nil {
+		return err
+	}
+	if err := e.writeInt(len(v)); err != nil {
+		return err
+	}
+	if err := e.writeBytes(':', '"'); err != nil {
+		return err
+	}
+	if err := e.writeString(v); err != nil {
+		return err
+	}
+	return e.writeBytes('"', ';')
 }
 
 func (e *Encoder) EncodeBytes(v []byte) error {
@@ -172,25 +317,139 @@ func (e *Encoder) EncodeFloat64(v float64) error {
 	if err := e.writeBytes('d', ':'); err != nil {
 		return err
 	}
-	if math.IsInf(v, -1) {
-		if err := e.writeString(`-INF`); err != nil {
-			return err
+	b := e.scratch[:0]
+	if e.flags&serializePrecisionFlag != 0 {
+		b = appendGcvt(b, v, e.precision)
+	} else {
+		b = appendFloat(b, v)
+	}
+	if err := e.write(b); err != nil {
+		return err
+	}
+	return e.writeBytes(';')
+}
+
+// SetSerializePrecision makes the Encoder write floats exactly as PHP's
+// serialize does with the serialize_precision ini setting set to precision.
+// -1, the default since PHP 7.1, writes the fewest digits that read back
+// as the same float, e.g. 1.0E+25 and 0.1. 17, the default before, writes
+// up to 17 significant digits, e.g. 0.10000000000000001. Without it floats
+// are written in decimal notation, e.g. 10000000000000000905969664.
+func (e *Encoder) SetSerializePrecision(precision int) {
+	e.flags |= serializePrecisionFlag
+	e.precision = precision
+}
+
+// formatFloat returns the text PHP uses for v in serialized floats.
+func formatFloat(v float64) string {
+	return string(appendFloat(nil, v))
+}
+
+// appendFloat appends the text PHP uses for v in serialized floats to b.
+func appendFloat(b []byte, v float64) []byte {
+	switch {
+	case math.IsInf(v, -1):
+		return append(b, `-INF`...)
+	case math.IsInf(v, 1):
+		return append(b, `INF`...)
+	case math.IsNaN(v):
+		return append(b, `NAN`...)
+	}
+	return strconv.AppendFloat(b, v, 'f', -1, 64)
+}
+
+// appendGcvt appends v formatted like PHP's zend_gcvt formats it for
+// serialize: precision significant digits, or the fewest that round trip
+// when precision is -1, in exponent form when the decimal point lies more
+// than 4 places left of the digits or past the precision.
+func appendGcvt(b []byte, v float64, precision int) []byte {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return appendFloat(b, v)
+	}
+
+	// Like zend_dtoa, get the significant digits without trailing zeros
+	// and the position of the decimal point relative to them.
+	ndigit, prec := precision, precision-1
+	if precision < 0 {
+		ndigit, prec = 17, -1
+	} else if precision == 0 {
+		ndigit, prec = 1, 0
+	}
+	var buf [32]byte
+	mant := strconv.AppendFloat(buf[:0], math.Abs(v), 'e', prec, 64)
+	i := bytes.IndexByte(mant, 'e')
+	exp := 0
+	for _, c := range mant[i+2:] {
+		exp = exp*10 + int(c-'0')
+	}
+	if mant[i+1] == '-' {
+		exp = -exp
+	}
+	digits := mant[:1]
+	if i > 1 {
+		digits = append(digits, mant[2:i]...)
+	}
+	for len(digits) > 1 && digits[len(digits)-1] == '0' {
+		digits = digits[:len(digits)-1]
+	}
+	decpt := exp + 1
+
+	if math.Signbit(v) {
+		b = append(b, '-')
+	}
+	switch {
+	case decpt < -3 || decpt > ndigit:
+		b = append(b, digits[0], '.')
+		if len(digits) == 1 {
+			b = append(b, '0')
+		} else {
+			b = append(b, digits[1:]...)
 		}
-	} else if math.IsInf(v, 1) {
-		if err := e.writeString(`INF`); err != nil {
-			return err
+		b = append(b, 'E')
+		if exp >= 0 {
+			b = append(b, '+')
 		}
-	} else if math.IsNaN(v) {
-		if err := e.writeString(`NAN`); err != nil {
-			return err
+		return strconv.AppendInt(b, int64(exp), 10)
+	case decpt <= 0:
+		b = append(b, '0', '.')
+		for ; decpt < 0; decpt++ {
+			b = append(b, '0')
 		}
-	} else {
-		if err := e.writeFloat64(v); err != nil {
-			return err
+		return append(b, digits...)
+	case len(digits) <= decpt:
+		b = append(b, digits...)
+		for i := len(digits); i < decpt; i++ {
+			b = append(b, '0')
 		}
+		return b
 	}
+	b = append(b, digits[:decpt]...)
+	b = append(b, '.')
+	return append(b, digits[decpt:]...)
+}
 
-	return e.writeBytes(';')
+// EncodeObjectLen writes the header of an object of class with n
+// properties. The properties are to be followed by EncodeArrayEnd.
+func (e *Encoder) EncodeObjectLen(class string, n int) error {
+	if err := e.writeBytes('O', ':'); err != nil {
+		return err
+	}
+	if err := e.writeInt(len(class)); err != nil {
+		return err
+	}
+	if err := e.writeBytes(':', '"'); err != nil {
+		return err
+	}
+	if err := e.writeString(class); err != nil {
+		return err
+	}
+	if err := e.writeBytes('"', ':'); err != nil {
+		return err
+	}
+	if err := e.writeInt(n); err != nil {
+		return err
+	}
+	return e.writeBytes(':', '{')
 }
 
 func (e *Encoder) write(b []byte) error {
@@ -199,28 +458,30 @@ func (e *Encoder) write(b []byte) error {
 }
 
 func (e *Encoder) writeBytes(b ...byte) error {
-	_, err := e.w.Write(b)
+	if len(b) == 1 {
+		return e.w.WriteByte(b[0])
+	}
+	_, err := e.w.Write(append(e.scratch[:0], b...))
 	return err
 }
 
 func (e *Encoder) writeString(s string) error {
-	// _, err := e.w.Write(stringToBytes(s))
+	if e.sw != nil {
+		_, err := e.sw.WriteString(s)
+		return err
+	}
 	_, err := e.w.Write([]byte(s))
 	return err
 }
 
 func (e *Encoder) writeInt(v int) error {
-	return e.writeString(strconv.Itoa(v))
+	return e.writeInt64(int64(v))
 }
 
 func (e *Encoder) writeInt64(v int64) error {
-	return e.writeString(strconv.FormatInt(v, 10))
+	return e.write(strconv.AppendInt(e.scratch[:0], v, 10))
 }
 
 func (e *Encoder) writeUint64(v uint64) error {
-	return e.writeString(strconv.FormatUint(v, 10))
-}
-
-func (e *Encoder) writeFloat64(v float64) error {
-	return e.writeString(strconv.FormatFloat(v, 'f', -1, 64))
+	return e.write(strconv.AppendUint(e.scratch[:0], v, 10))
 }
@@ -7,11 +7,15 @@ func encodeMapValue(e *Encoder, v reflect.Value) error {
 		return e.EncodeNil()
 	}
 
-	if err := e.writeArrayPrefixLen(v.Len()); err != nil {
+	if err := e.EncodeArrayLen(v.Len()); err != nil {
 		return err
 	}
 
-	for _, key := range v.MapKeys() {
+	keys := v.MapKeys()
+	if e.flags&unsortedMapKeysFlag == 0 {
+		sortMapKeys(keys)
+	}
+	for _, key := range keys {
 		if err := e.EncodeValue(key); err != nil {
 			return err
 		}
@@ -23,7 +27,9 @@ func encodeMapValue(e *Encoder, v reflect.Value) error {
 	return e.writeBytes('}')
 }
 
-func (e *Encoder) writeArrayPrefixLen(len int) error {
+// EncodeArrayLen writes the header of an array of len elements, each a key
+// followed by a value. The elements are to be followed by EncodeArrayEnd.
+func (e *Encoder) EncodeArrayLen(len int) error {
 	if err := e.writeBytes('a', ':'); err != nil {
 		return err
 	}
@@ -33,6 +39,20 @@ func (e *Encoder) writeArrayPrefixLen(len int) error {
 	return e.writeBytes(':', '{')
 }
 
+// EncodeArrayEnd writes the closing brace of an array or object.
+func (e *Encoder) EncodeArrayEnd() error {
+	return e.writeBytes('}')
+}
+
+// EncodeStructLen writes the header of a struct of type typ with n fields:
+// an object of the class typ is registered for, or else an array.
+func (e *Encoder) EncodeStructLen(typ reflect.Type, n int) error {
+	if class, ok := registeredClass(typ); ok {
+		return e.EncodeObjectLen(class, n)
+	}
+	return e.EncodeArrayLen(n)
+}
+
 func encodeStructValue(e *Encoder, strct reflect.Value) error {
 	structFields := structs.Fields(strct.Type(), `php`) // e.structTag)
 	/*if e.flags&arrayEncodedStructsFlag != 0 || structFields.AsArray {
@@ -40,7 +60,7 @@ func encodeStructValue(e *Encoder, strct reflect.Value) error {
 	}*/
 	fields := structFields.OmitEmpty(strct)
 
-	if err := e.writeArrayPrefixLen(len(fields)); err != nil {
+	if err := e.EncodeStructLen(strct.Type(), len(fields)); err != nil {
 		return err
 	}
 
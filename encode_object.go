@@ -0,0 +1,76 @@
+package phpserialize
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// EncodeIncompleteObject writes obj exactly as it was decoded: its class,
+// its properties in their original order with their mangled names and
+// their values verbatim.
+func (e *Encoder) EncodeIncompleteObject(obj IncompleteObject) error {
+	if obj.Custom {
+		return e.encodeCustomObject(obj.Class, obj.Data)
+	}
+
+	if err := e.EncodeObjectLen(obj.Class, len(obj.Properties)); err != nil {
+		return err
+	}
+	for _, p := range obj.Properties {
+		if err := e.encodePropertyName(p); err != nil {
+			return err
+		}
+		if len(p.Value) == 0 {
+			if err := e.EncodeNil(); err != nil {
+				return err
+			}
+		} else if err := e.write(p.Value); err != nil {
+			return err
+		}
+	}
+	return e.writeBytes('}')
+}
+
+func (e *Encoder) encodePropertyName(p ObjectProperty) error {
+	if p.intKey {
+		if n, err := strconv.ParseInt(p.Name, 10, 64); err == nil {
+			return e.EncodeInt64(n)
+		}
+	}
+	return e.EncodeString(p.Name)
+}
+
+/**
+  C:11:"ArrayObject":21:{x:i:0;a:0:{};m:a:0:{}}
+*/
+func (e *Encoder) encodeCustomObject(class string, data []byte) error {
+	if err := e.writeBytes('C', ':'); err != nil {
+		return err
+	}
+	if err := e.writeInt(len(class)); err != nil {
+		return err
+	}
+	if err := e.writeBytes(':', '"'); err != nil {
+		return err
+	}
+	if err := e.writeString(class); err != nil {
+		return err
+	}
+	if err := e.writeBytes('"', ':'); err != nil {
+		return err
+	}
+	if err := e.writeInt(len(data)); err != nil {
+		return err
+	}
+	if err := e.writeBytes(':', '{'); err != nil {
+		return err
+	}
+	if err := e.write(data); err != nil {
+		return err
+	}
+	return e.writeBytes('}')
+}
+
+func encodeIncompleteObjectValue(e *Encoder, v reflect.Value) error {
+	return e.EncodeIncompleteObject(v.Interface().(IncompleteObject))
+}
@@ -11,7 +11,7 @@ func encodeSliceValue(e *Encoder, v reflect.Value) error {
 
 func encodeArrayValue(e *Encoder, v reflect.Value) error {
 	l := v.Len()
-	if err := e.writeArrayPrefixLen(l); err != nil {
+	if err := e.EncodeArrayLen(l); err != nil {
 		return err
 	}
 	for i := 0; i < l; i++ {
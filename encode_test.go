@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/suite"
 	"math"
 	"testing"
+	"time"
 )
 
 type EncodeSuite struct {
@@ -28,6 +29,17 @@ func (w *maxLengthWriter) Write(b []byte) (int, error) {
 	return w.buffer.Write(b)
 }
 
+// writeCounter counts the writes made to it.
+type writeCounter struct {
+	writes int
+	buffer bytes.Buffer
+}
+
+func (w *writeCounter) Write(b []byte) (int, error) {
+	w.writes++
+	return w.buffer.Write(b)
+}
+
 func (Suite *EncodeSuite) TestMarshalString() {
 	Suite.assertMarshal(`Hello`, `s:5:"Hello";`)
 	Suite.assertMarshalContained(`World`, `s:5:"World";`)
@@ -89,6 +101,59 @@ func (Suite *EncodeSuite) TestMarshalFloats() {
 	Suite.assertMarshalContained(float32(math.NaN()), `d:NAN;`)
 }
 
+func (Suite *EncodeSuite) TestSerializePrecision() {
+	encode := func(precision int, v interface{}) string {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetSerializePrecision(precision)
+		Suite.Nil(e.Encode(v))
+		return buf.String()
+	}
+
+	for v, want := range map[float64]string{
+		0.1:                         `d:0.1;`,
+		0.5:                         `d:0.5;`,
+		100:                         `d:100;`,
+		12345.678:                   `d:12345.678;`,
+		1e25:                        `d:1.0E+25;`,
+		1.5e300:                     `d:1.5E+300;`,
+		0.0001:                      `d:0.0001;`,
+		1e-5:                        `d:1.0E-5;`,
+		-2.5e-10:                    `d:-2.5E-10;`,
+		1e16:                        `d:10000000000000000;`,
+		123456789012345678:          `d:1.2345678901234568E+17;`,
+		math.Copysign(0, -1):        `d:-0;`,
+		math.Inf(-1):                `d:-INF;`,
+		math.MaxFloat64:             `d:1.7976931348623157E+308;`,
+		math.SmallestNonzeroFloat64: `d:5.0E-324;`,
+	} {
+		Suite.Equal(want, encode(-1, v), `%v`, v)
+	}
+
+	for v, want := range map[float64]string{
+		0.1:  `d:0.10000000000000001;`,
+		0.5:  `d:0.5;`,
+		100:  `d:100;`,
+		1e25: `d:1.0000000000000001E+25;`,
+		1e17: `d:1.0E+17;`,
+		1e-5: `d:1.0000000000000001E-5;`,
+		-1.1: `d:-1.1000000000000001;`,
+	} {
+		Suite.Equal(want, encode(17, v), `%v`, v)
+	}
+	Suite.Equal(`d:0.3;`, encode(1, 0.26))
+	Suite.Equal(`d:0.2;`, encode(1, 0.25))
+	Suite.Equal(`d:2.0E+2;`, encode(1, 250.0))
+
+	// Floats built in Go are formatted by the Encoder, while decoded ones
+	// keep their text.
+	Suite.Equal(`d:1.0E+25;`, encode(-1, FloatValue(1e25)))
+	Suite.Equal(`a:1:{i:0;d:0.10000000000000001;}`, encode(17, []*Value{FloatValue(0.1)}))
+	var v Value
+	Suite.Nil(UnmarshalString(`d:1.50;`, &v))
+	Suite.Equal(`d:1.50;`, encode(-1, &v))
+}
+
 func (Suite *EncodeSuite) TestMarshalBool() {
 	Suite.assertMarshal(true, `b:1;`)
 	Suite.assertMarshal(false, `b:0;`)
@@ -141,6 +206,192 @@ func (Suite *EncodeSuite) TestMarshalStringKeyMap() {
 	}
 }
 
+func (Suite *EncodeSuite) TestMarshalTime() {
+	utc := time.Date(2021, 5, 3, 12, 30, 15, 250000000, time.UTC)
+	Suite.assertMarshal(utc, `O:8:"DateTime":3:{s:4:"date";s:26:"2021-05-03 12:30:15.250000";s:13:"timezone_type";i:3;s:8:"timezone";s:3:"UTC";}`)
+	Suite.assertMarshal(utc.In(time.FixedZone(`-03:30`, -12600)), `O:8:"DateTime":3:{s:4:"date";s:26:"2021-05-03 09:00:15.250000";s:13:"timezone_type";i:1;s:8:"timezone";s:6:"-03:30";}`)
+	Suite.assertMarshal(utc.In(time.FixedZone(`CET`, 3600)), `O:8:"DateTime":3:{s:4:"date";s:26:"2021-05-03 13:30:15.250000";s:13:"timezone_type";i:2;s:8:"timezone";s:3:"CET";}`)
+	Suite.assertMarshal(utc.In(time.FixedZone(`Custom`, 7200)), `O:8:"DateTime":3:{s:4:"date";s:26:"2021-05-03 14:30:15.250000";s:13:"timezone_type";i:1;s:8:"timezone";s:6:"+02:00";}`)
+
+	type container struct {
+		Created  time.Time  `php:"created,unix"`
+		Updated  *time.Time `php:"updated,string"`
+		Deleted  *time.Time `php:"deleted,string"`
+		Archived time.Time  `php:"archived,immutable"`
+	}
+	Suite.assertMarshal(container{Created: utc, Updated: &utc, Archived: utc},
+		`a:4:{s:7:"created";i:1620045015;s:7:"updated";s:19:"2021-05-03 12:30:15";s:7:"deleted";N;`+
+			`s:8:"archived";O:17:"DateTimeImmutable":3:{s:4:"date";s:26:"2021-05-03 12:30:15.250000";s:13:"timezone_type";i:3;s:8:"timezone";s:3:"UTC";}}`)
+
+	// With several format options, the first in the option list wins.
+	type ambiguous struct {
+		At time.Time `php:"at,string,unix"`
+	}
+	for i := 0; i < 10; i++ {
+		Suite.assertMarshal(ambiguous{At: utc}, `a:1:{s:2:"at";i:1620045015;}`)
+	}
+}
+
+func (Suite *EncodeSuite) TestEncoder_SetTimeFormat() {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetTimeFormat(TimeAsUnix)
+	Suite.Nil(e.Encode(time.Unix(1620045015, 0)))
+	Suite.Equal(`i:1620045015;`, buf.String())
+}
+
+func (Suite *EncodeSuite) TestTimeRoundTrip() {
+	for _, data := range []string{
+		`O:8:"DateTime":3:{s:4:"date";s:26:"2021-05-03 12:30:15.123456";s:13:"timezone_type";i:1;s:8:"timezone";s:6:"+05:30";}`,
+		`O:8:"DateTime":3:{s:4:"date";s:26:"2021-05-03 12:30:15.000000";s:13:"timezone_type";i:2;s:8:"timezone";s:3:"PDT";}`,
+		`O:8:"DateTime":3:{s:4:"date";s:26:"2021-05-03 12:30:15.000000";s:13:"timezone_type";i:3;s:8:"timezone";s:3:"UTC";}`,
+	} {
+		var t time.Time
+		Suite.Nil(UnmarshalString(data, &t))
+		Suite.assertMarshal(t, data)
+	}
+}
+
+func (Suite *EncodeSuite) TestMarshalDuration() {
+	Suite.assertMarshal(1500*time.Millisecond, `i:1500000000;`)
+	Suite.assertMarshalContained(time.Second, `i:1000000000;`)
+
+	timeout := 90 * time.Second
+	type container struct {
+		Timeout *time.Duration `php:"timeout,seconds"`
+		Delay   time.Duration  `php:"delay,seconds"`
+	}
+	Suite.assertMarshal(container{Timeout: &timeout, Delay: 250 * time.Millisecond}, `a:2:{s:7:"timeout";i:90;s:5:"delay";d:0.25;}`)
+	Suite.assertMarshal(container{}, `a:2:{s:7:"timeout";N;s:5:"delay";i:0;}`)
+}
+
+func (Suite *EncodeSuite) TestMarshalDateInterval() {
+	days := 31
+	Suite.assertMarshal(DateInterval{M: 1, H: 2, F: 0.25, Invert: true, Days: &days},
+		`O:12:"DateInterval":10:{s:1:"y";i:0;s:1:"m";i:1;s:1:"d";i:0;s:1:"h";i:2;s:1:"i";i:0;s:1:"s";i:0;s:1:"f";d:0.25;`+
+			`s:6:"invert";i:1;s:4:"days";i:31;s:11:"from_string";b:0;}`)
+	Suite.assertMarshal(&DateInterval{D: 1},
+		`O:12:"DateInterval":10:{s:1:"y";i:0;s:1:"m";i:0;s:1:"d";i:1;s:1:"h";i:0;s:1:"i";i:0;s:1:"s";i:0;s:1:"f";d:0;`+
+			`s:6:"invert";i:0;s:4:"days";b:0;s:11:"from_string";b:0;}`)
+}
+
+func (Suite *EncodeSuite) TestMarshalIncompleteObject() {
+	for _, data := range []string{
+		"O:7:\"Unknown\":3:{s:2:\"id\";i:7;s:7:\"\x00*\x00tags\";a:1:{i:0;s:1:\"a\";}s:13:\"\x00Unknown\x00next\";O:3:\"Foo\":0:{}}",
+		`O:8:"stdClass":2:{i:0;b:1;i:1;d:0.5;}`,
+		`C:11:"ArrayObject":21:{x:i:0;a:0:{};m:a:0:{}}`,
+	} {
+		var v interface{}
+		Suite.Nil(UnmarshalString(data, &v))
+		Suite.assertMarshal(v, data)
+	}
+}
+
+func (Suite *EncodeSuite) TestMarshalRegisteredClass() {
+	type Point struct {
+		X int `php:"x"`
+		Y int `php:"y"`
+	}
+	RegisterClass(`App\Point`, &Point{})
+
+	data := `O:9:"App\Point":2:{s:1:"x";i:1;s:1:"y";i:2;}`
+	Suite.assertMarshal(Point{X: 1, Y: 2}, data)
+
+	var v interface{}
+	Suite.Nil(UnmarshalString(data, &v))
+	Suite.Equal(&Point{X: 1, Y: 2}, v)
+}
+
+func (Suite *EncodeSuite) TestMarshalRawMessage() {
+	type Envelope struct {
+		Type    string      `php:"type"`
+		Payload RawMessage  `php:"payload"`
+		Extra   *RawMessage `php:"extra"`
+	}
+	Suite.assertMarshal(Envelope{Type: `order`, Payload: RawMessage(`a:1:{s:2:"id";i:7;}`)},
+		`a:3:{s:4:"type";s:5:"order";s:7:"payload";a:1:{s:2:"id";i:7;}s:5:"extra";N;}`)
+	Suite.assertMarshal(RawMessage(nil), `N;`)
+	Suite.assertMarshal(RawMessage{}, `N;`)
+	Suite.assertMarshal(Envelope{Type: `order`, Payload: RawMessage{}}, `a:3:{s:4:"type";s:5:"order";s:7:"payload";N;s:5:"extra";N;}`)
+}
+
+// version marshals itself as a "major.minor" string.
+type version struct {
+	Major, Minor int
+}
+
+func (v version) MarshalPHP() ([]byte, error) {
+	return Marshal(fmt.Sprintf(`%d.%d`, v.Major, v.Minor))
+}
+
+func (v *version) UnmarshalPHP(b []byte) error {
+	var s string
+	if err := Unmarshal(b, &s); err != nil {
+		return err
+	}
+	_, err := fmt.Sscanf(s, `%d.%d`, &v.Major, &v.Minor)
+	return err
+}
+
+// cents encodes itself through the Encoder as a float of whole units.
+type cents int64
+
+func (c *cents) EncodePHP(e *Encoder) error {
+	return e.EncodeFloat64(float64(*c) / 100)
+}
+
+func (c *cents) DecodePHP(d *Decoder) error {
+	f, err := d.DecodeFloat64()
+	*c = cents(math.Round(f * 100))
+	return err
+}
+
+func (Suite *EncodeSuite) TestMarshalCustom() {
+	type Release struct {
+		Version version  `php:"version"`
+		Price   cents    `php:"price"`
+		Next    *version `php:"next"`
+	}
+	Suite.assertMarshal(version{8, 1}, `s:3:"8.1";`)
+	Suite.assertMarshal(&version{8, 1}, `s:3:"8.1";`)
+	Suite.assertMarshal((*version)(nil), `N;`)
+	Suite.assertMarshal(cents(1250), `d:12.5;`)
+	Suite.assertMarshal(Release{Version: version{7, 4}, Price: 999},
+		`a:3:{s:7:"version";s:3:"7.4";s:5:"price";d:9.99;s:4:"next";N;}`)
+}
+
+func (Suite *EncodeSuite) TestMarshalSortedMapKeys() {
+	Suite.assertMarshal(map[string]int{`b`: 1, `a`: 2, `10`: 3, `9`: 4},
+		`a:4:{s:1:"9";i:4;s:2:"10";i:3;s:1:"a";i:2;s:1:"b";i:1;}`)
+	Suite.assertMarshal(map[int]bool{3: true, -1: false, 20: true},
+		`a:3:{i:-1;b:0;i:3;b:1;i:20;b:1;}`)
+
+	// Mixed keys compare numerically against numeric strings and as
+	// strings otherwise, like ksort in PHP 8.
+	Suite.assertMarshal(map[interface{}]int{int64(10): 1, `9`: 2, `a`: 3, int64(-1): 4, `1.5`: 5},
+		`a:5:{i:-1;i:4;s:3:"1.5";i:5;s:1:"9";i:2;i:10;i:1;s:1:"a";i:3;}`)
+	Suite.assertMarshal(map[interface{}]int{int64(5): 1, int64(20): 2, `10abc`: 3},
+		`a:3:{s:5:"10abc";i:3;i:5;i:1;i:20;i:2;}`)
+
+	m := make(map[string]int)
+	for i := 0; i < 50; i++ {
+		m[fmt.Sprintf(`k%d`, i)] = i
+	}
+	first, err := Marshal(m)
+	Suite.Nil(err)
+	for i := 0; i < 10; i++ {
+		b, err := Marshal(m)
+		Suite.Nil(err)
+		Suite.Equal(string(first), string(b))
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetSortMapKeys(false)
+	Suite.Nil(e.Encode(map[int]int{1: 1, 2: 2}))
+	Suite.Contains([]string{`a:2:{i:1;i:1;i:2;i:2;}`, `a:2:{i:2;i:2;i:1;i:1;}`}, buf.String())
+}
+
 func (Suite *EncodeSuite) TestUnsupported() {
 	b, err := Marshal(complex64(123))
 	Suite.Nil(b)
@@ -224,6 +475,79 @@ func (Suite *EncodeSuite) TestNewByteWriter() {
 	Suite.Equal(errCapacityExceeded, w.WriteByte(';'))
 }
 
+func (Suite *EncodeSuite) TestEncoderBuffersWriter() {
+	// Without buffering every part of a value is a separate write.
+	w := &writeCounter{}
+	Suite.Nil(NewEncoder(w).Encode([]int{10, 92}))
+	Suite.Equal(16, w.writes)
+	Suite.Equal(`a:2:{i:0;i:10;i:1;i:92;}`, w.buffer.String())
+
+	// A buffered writer without WriteByte receives each encoded value in
+	// one write.
+	w = &writeCounter{}
+	e := NewBufferedEncoder(w)
+	Suite.Nil(e.Encode([]int{10, 92}))
+	Suite.Equal(1, w.writes)
+	Suite.Equal(`a:2:{i:0;i:10;i:1;i:92;}`, w.buffer.String())
+
+	Suite.Nil(e.EncodeInt64(5))
+	Suite.Equal(1, w.writes)
+	Suite.Nil(e.Flush())
+	Suite.Equal(2, w.writes)
+	Suite.Equal(`a:2:{i:0;i:10;i:1;i:92;}i:5;`, w.buffer.String())
+
+	// Errors surface once the buffer is flushed.
+	buf := &bytes.Buffer{}
+	e = NewBufferedEncoder(&maxLengthWriter{
+		capacity: 6,
+		buffer:   buf,
+	})
+	Suite.Equal(errCapacityExceeded, e.Encode(1234))
+	Suite.Equal(``, buf.String())
+
+	// Reset keeps the Encoder buffered.
+	w = &writeCounter{}
+	e.Reset(w)
+	Suite.Nil(e.Encode([]int{10, 92}))
+	Suite.Equal(1, w.writes)
+}
+
+func (Suite *EncodeSuite) TestEncoderReset() {
+	var first, second bytes.Buffer
+	e := GetEncoder()
+	e.Reset(&first)
+	e.SetTimeFormat(TimeAsUnix)
+	Suite.Nil(e.Encode(time.Unix(1600000000, 0)))
+	e.Reset(&second)
+	Suite.Nil(e.Encode(time.Unix(1600000000, 0)))
+	e.SetSerializePrecision(17)
+	PutEncoder(e)
+	Suite.Zero(e.flags)
+	Suite.Zero(e.precision)
+
+	Suite.Equal(`i:1600000000;`, first.String())
+	Suite.Equal(`i:1600000000;`, second.String())
+}
+
+func (Suite *EncodeSuite) TestAppendMarshal() {
+	dst := []byte(`prefix:`)
+	b, err := AppendMarshal(dst, map[string]int{`a`: 1})
+	Suite.Nil(err)
+	Suite.Equal(`prefix:a:1:{s:1:"a";i:1;}`, string(b))
+
+	b, err = AppendMarshal(dst, make(chan int))
+	Suite.NotNil(err)
+	Suite.Equal(`prefix:`, string(b))
+
+	buf := make([]byte, 0, 64)
+	var v interface{} = []int{10, 92}
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = AppendMarshal(buf[:0], v)
+	})
+	Suite.Equal(float64(0), allocs)
+	Suite.Equal(`a:2:{i:0;i:10;i:1;i:92;}`, string(buf))
+}
+
 func (Suite *EncodeSuite) assertCapacityErr(Capacity int, Value interface{}, ExpectedBuffer string) {
 	// These tests use a write with a max length to ensure error propagation
 
@@ -256,3 +580,31 @@ func (Suite *EncodeSuite) assertMarshalContained(v interface{}, expected string)
 func TestEncodeSuite(t *testing.T) {
 	suite.Run(t, new(EncodeSuite))
 }
+
+func BenchmarkMarshal(b *testing.B) {
+	var v interface{} = struct {
+		ID    int      `php:"id"`
+		Name  string   `php:"name"`
+		Score float64  `php:"score"`
+		Tags  []string `php:"tags"`
+	}{1, `Jane`, 9.5, []string{`a`, `b`, `c`}}
+
+	b.Run(`Marshal`, func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := Marshal(v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run(`AppendMarshal`, func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			var err error
+			if buf, err = AppendMarshal(buf[:0], v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package phpserialize
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// TimeFormat selects how the Encoder represents a time.Time.
+type TimeFormat int
+
+const (
+	// TimeAsDateTime encodes times as serialized PHP DateTime objects.
+	TimeAsDateTime TimeFormat = iota
+	// TimeAsDateTimeImmutable encodes times as serialized PHP DateTimeImmutable objects.
+	TimeAsDateTimeImmutable
+	// TimeAsUnix encodes times as integer unix timestamps.
+	TimeAsUnix
+	// TimeAsString encodes times as Y-m-d H:i:s strings in the time's own location.
+	TimeAsString
+)
+
+// timeFormatOptions lists the struct tag options and the TimeFormat they
+// select, e.g. `php:"created_at,unix"`, in the order they are looked for.
+// The first option a tag has wins.
+var timeFormatOptions = [...]struct {
+	option string
+	format TimeFormat
+}{
+	{`datetime`, TimeAsDateTime},
+	{`immutable`, TimeAsDateTimeImmutable},
+	{`unix`, TimeAsUnix},
+	{`string`, TimeAsString},
+}
+
+// knownLocations caches which location names PHP can resolve as a
+// timezone identifier.
+var knownLocations sync.Map
+
+// SetTimeFormat sets the representation used for time.Time values that do
+// not select one with a struct tag option. The default is TimeAsDateTime.
+func (e *Encoder) SetTimeFormat(format TimeFormat) {
+	e.timeFormat = format
+}
+
+func (e *Encoder) EncodeTime(t time.Time) error {
+	return e.encodeTime(t, e.timeFormat)
+}
+
+// EncodeTimeAs writes t in format regardless of the Encoder's time format,
+// as a struct field with a time format tag option is written.
+func (e *Encoder) EncodeTimeAs(t time.Time, format TimeFormat) error {
+	return e.encodeTime(t, format)
+}
+
+func (e *Encoder) encodeTime(t time.Time, format TimeFormat) error {
+	switch format {
+	case TimeAsDateTime:
+		return e.encodeDateTime(`DateTime`, t)
+	case TimeAsDateTimeImmutable:
+		return e.encodeDateTime(`DateTimeImmutable`, t)
+	case TimeAsUnix:
+		return e.EncodeInt64(t.Unix())
+	case TimeAsString:
+		return e.EncodeString(t.Format(phpDateLayout))
+	}
+	return fmt.Errorf(`phpserialize: Encode(unknown time format %d)`, format)
+}
+
+func (e *Encoder) encodeDateTime(class string, t time.Time) error {
+	zoneType, zone := phpTimezone(t)
+
+	if err := e.EncodeObjectLen(class, 3); err != nil {
+		return err
+	}
+	if err := e.EncodeString(`date`); err != nil {
+		return err
+	}
+	if err := e.EncodeString(t.Format(phpDateTimeLayout)); err != nil {
+		return err
+	}
+	if err := e.EncodeString(`timezone_type`); err != nil {
+		return err
+	}
+	if err := e.EncodeInt64(int64(zoneType)); err != nil {
+		return err
+	}
+	if err := e.EncodeString(`timezone`); err != nil {
+		return err
+	}
+	if err := e.EncodeString(zone); err != nil {
+		return err
+	}
+	return e.writeBytes('}')
+}
+
+// phpTimezone returns the timezone_type and timezone PHP would store for
+// the location of t. Locations PHP cannot name fall back to an offset.
+func phpTimezone(t time.Time) (int, string) {
+	loc := t.Location()
+	if loc == time.UTC {
+		return 3, `UTC`
+	}
+
+	name := loc.String()
+	if _, ok := parseTimezoneOffset(name); ok {
+		return 1, name
+	}
+	if abbr, _ := t.Zone(); abbr == name {
+		if _, ok := timezoneAbbreviations[name]; ok {
+			return 2, name
+		}
+	}
+	if name != `Local` && isKnownLocation(name) {
+		return 3, name
+	}
+
+	_, offset := t.Zone()
+	return 1, formatTimezoneOffset(offset)
+}
+
+func isKnownLocation(name string) bool {
+	if v, ok := knownLocations.Load(name); ok {
+		return v.(bool)
+	}
+	_, err := time.LoadLocation(name)
+	knownLocations.Store(name, err == nil)
+	return err == nil
+}
+
+func formatTimezoneOffset(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf(`%c%02d:%02d`, sign, offset/3600, offset%3600/60)
+}
+
+func encodeTimeValue(e *Encoder, v reflect.Value) error {
+	return e.EncodeTime(v.Interface().(time.Time))
+}
+
+// timeFieldEncoder returns the encoder for a time.Time or *time.Time field
+// whose tag selects a specific TimeFormat.
+func timeFieldEncoder(typ reflect.Type, format TimeFormat) encoderFunc {
+	encode := func(e *Encoder, v reflect.Value) error {
+		return e.encodeTime(v.Interface().(time.Time), format)
+	}
+	if typ.Kind() == reflect.Ptr {
+		return ptrEncoderFuncWith(encode)
+	}
+	return encode
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// durationSecondsEncoder returns the encoder for a time.Duration or
+// *time.Duration field tagged with the seconds option. Whole seconds are
+// written as integers, anything finer as a float.
+func durationSecondsEncoder(typ reflect.Type) encoderFunc {
+	encode := func(e *Encoder, v reflect.Value) error {
+		return e.EncodeDurationSeconds(time.Duration(v.Int()))
+	}
+	if typ.Kind() == reflect.Ptr {
+		return ptrEncoderFuncWith(encode)
+	}
+	return encode
+}
+
+// EncodeDurationSeconds writes d as a number of seconds, as a field tagged
+// with the seconds option is written: an integer when d is a whole number
+// of seconds, a float otherwise.
+func (e *Encoder) EncodeDurationSeconds(d time.Duration) error {
+	if d%time.Second == 0 {
+		return e.EncodeInt64(int64(d / time.Second))
+	}
+	return e.EncodeFloat64(d.Seconds())
+}
+
+var dateIntervalType = reflect.TypeOf((*DateInterval)(nil)).Elem()
+
+// DateInterval mirrors the properties of a PHP DateInterval object.
+type DateInterval struct {
+	Y, M, D int
+	H, I, S int
+	// F is the fraction of a second.
+	F      float64
+	Invert bool
+	// Days is the total number of days when the interval was created by
+	// DateTime::diff, nil where PHP stores false.
+	Days *int
+}
+
+func (e *Encoder) EncodeDateInterval(v DateInterval) error {
+	if err := e.EncodeObjectLen(`DateInterval`, 10); err != nil {
+		return err
+	}
+	for _, p := range []struct {
+		name  string
+		value int
+	}{
+		{`y`, v.Y},
+		{`m`, v.M},
+		{`d`, v.D},
+		{`h`, v.H},
+		{`i`, v.I},
+		{`s`, v.S},
+	} {
+		if err := e.EncodeString(p.name); err != nil {
+			return err
+		}
+		if err := e.EncodeInt64(int64(p.value)); err != nil {
+			return err
+		}
+	}
+	if err := e.EncodeString(`f`); err != nil {
+		return err
+	}
+	if err := e.EncodeFloat64(v.F); err != nil {
+		return err
+	}
+	if err := e.EncodeString(`invert`); err != nil {
+		return err
+	}
+	var invert int64
+	if v.Invert {
+		invert = 1
+	}
+	if err := e.EncodeInt64(invert); err != nil {
+		return err
+	}
+	if err := e.EncodeString(`days`); err != nil {
+		return err
+	}
+	if v.Days != nil {
+		if err := e.EncodeInt64(int64(*v.Days)); err != nil {
+			return err
+		}
+	} else if err := e.EncodeBool(false); err != nil {
+		return err
+	}
+	if err := e.EncodeString(`from_string`); err != nil {
+		return err
+	}
+	if err := e.EncodeBool(false); err != nil {
+		return err
+	}
+	return e.writeBytes('}')
+}
+
+func encodeDateIntervalValue(e *Encoder, v reflect.Value) error {
+	return e.EncodeDateInterval(v.Interface().(DateInterval))
+}
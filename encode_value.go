@@ -56,18 +56,12 @@ func _getEncoder(typ reflect.Type) encoderFunc {
 		}
 	}
 
-	/*if typ.Implements(customEncoderType) {
+	if typ.Implements(customEncoderType) {
 		return encodeCustomValue
 	}
 	if typ.Implements(marshalerType) {
 		return marshalValue
 	}
-	if typ.Implements(binaryMarshalerType) {
-		return marshalBinaryValue
-	}
-	if typ.Implements(textMarshalerType) {
-		return marshalTextValue
-	}
 
 	// Addressable struct field value.
 	if kind != reflect.Ptr {
@@ -78,6 +72,18 @@ func _getEncoder(typ reflect.Type) encoderFunc {
 		if ptr.Implements(marshalerType) {
 			return marshalValuePtr
 		}
+	}
+
+	/*if typ.Implements(binaryMarshalerType) {
+		return marshalBinaryValue
+	}
+	if typ.Implements(textMarshalerType) {
+		return marshalTextValue
+	}
+
+	// Addressable struct field value.
+	if kind != reflect.Ptr {
+		ptr := reflect.PtrTo(typ)
 		if ptr.Implements(binaryMarshalerType) {
 			return marshalBinaryValueAddr
 		}
@@ -86,6 +92,19 @@ func _getEncoder(typ reflect.Type) encoderFunc {
 		}
 	}*/
 
+	switch typ {
+	case timeType:
+		return encodeTimeValue
+	case dateIntervalType:
+		return encodeDateIntervalValue
+	case incompleteObjectType:
+		return encodeIncompleteObjectValue
+	case rawMessageType:
+		return encodeRawMessageValue
+	case treeType:
+		return encodeTreeValue
+	}
+
 	/*if typ == errorType {
 		return encodeErrorValue
 	}*/
@@ -124,7 +143,10 @@ func encodeUnsupportedValue(e *Encoder, v reflect.Value) error {
 }
 
 func ptrEncoderFunc(typ reflect.Type) encoderFunc {
-	encoder := getEncoder(typ.Elem())
+	return ptrEncoderFuncWith(getEncoder(typ.Elem()))
+}
+
+func ptrEncoderFuncWith(encoder encoderFunc) encoderFunc {
 	return func(e *Encoder, v reflect.Value) error {
 		if v.IsNil() {
 			return e.EncodeNil()
@@ -0,0 +1,125 @@
+package phpserialize
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A SyntaxError describes malformed serialized input.
+type SyntaxError struct {
+	msg string
+	// Offset is the input offset of the byte at which the error was found.
+	Offset int64
+}
+
+func (e *SyntaxError) Error() string {
+	return e.msg
+}
+
+func syntaxErrorf(offset int64, format string, args ...interface{}) error {
+	return &SyntaxError{
+		msg:    fmt.Sprintf(`phpserialize: Decode(`+format+`)`, args...),
+		Offset: offset,
+	}
+}
+
+// An UnmarshalTypeError describes a serialized value that could not be
+// stored in a Go value of the target type.
+type UnmarshalTypeError struct {
+	// Value describes the serialized value, e.g. "integer 128".
+	Value string
+	// Type is the Go type the value could not be assigned to.
+	Type reflect.Type
+	// Offset is the input offset at which the value starts.
+	Offset int64
+	// Field is the Go path of the value, e.g. Order.Items[3].Price. It is
+	// empty when the value is the top level target.
+	Field string
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != `` {
+		return `phpserialize: cannot unmarshal ` + e.Value + ` into Go field ` + e.Field + ` of type ` + e.Type.String()
+	}
+	return `phpserialize: cannot unmarshal ` + e.Value + ` into Go value of type ` + e.Type.String()
+}
+
+// WithField prefixes the path of an UnmarshalTypeError with name, which is
+// either a Go field name or an index such as [3], and returns err. Custom
+// decoders of structs use it to report which field failed.
+func WithField(err error, name string) error {
+	return withField(err, name)
+}
+
+func withField(err error, name string) error {
+	if e, ok := err.(*UnmarshalTypeError); ok {
+		switch {
+		case e.Field == ``:
+			e.Field = name
+		case e.Field[0] == '[':
+			e.Field = name + e.Field
+		default:
+			e.Field = name + `.` + e.Field
+		}
+	}
+	return err
+}
+
+// withType replaces the Type of an UnmarshalTypeError with the more
+// specific type of the value being decoded into.
+func withType(err error, typ reflect.Type) error {
+	if e, ok := err.(*UnmarshalTypeError); ok {
+		e.Type = typ
+	}
+	return err
+}
+
+// phpValueName describes the serialized value starting with code.
+func phpValueName(code byte) string {
+	switch code {
+	case 'N':
+		return `null`
+	case 'b':
+		return `bool`
+	case 'i':
+		return `integer`
+	case 'd':
+		return `float`
+	case 's':
+		return `string`
+	case 'a':
+		return `array`
+	case 'O', 'C':
+		return `object`
+	case 'r', 'R':
+		return `reference`
+	}
+	return fmt.Sprintf(`'%c'`, code)
+}
+
+// A LimitError reports that the input exceeded one of the limits configured
+// on the Decoder.
+type LimitError struct {
+	// Limit names the exceeded limit: depth, bytes, string length or elements.
+	Limit string
+	// Max is the configured maximum.
+	Max int64
+	// Offset is the input offset at which the limit was exceeded.
+	Offset int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf(`phpserialize: Decode(input exceeds max %s of %d)`, e.Limit, e.Max)
+}
+
+// A ClassNotAllowedError reports an object whose class is not allowed by
+// Decoder.SetAllowedClasses.
+type ClassNotAllowedError struct {
+	Class string
+	// Offset is the input offset at which the object starts.
+	Offset int64
+}
+
+func (e *ClassNotAllowedError) Error() string {
+	return fmt.Sprintf(`phpserialize: Decode(class %q is not allowed)`, e.Class)
+}
@@ -0,0 +1,489 @@
+package phpserialize
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Result is a value found by Get.
+type Result struct {
+	// Raw holds the serialized value, or is nil when nothing matched.
+	Raw RawMessage
+	// Index is the offset of Raw in the input, or -1 when nothing matched or
+	// Raw was assembled from the matches of a wildcard path.
+	Index int
+}
+
+// Exists reports whether the path matched a value.
+func (r Result) Exists() bool {
+	return r.Raw != nil
+}
+
+// Unmarshal decodes the value into v.
+func (r Result) Unmarshal(v interface{}) error {
+	return Unmarshal(r.Raw, v)
+}
+
+// Value decodes the value into a Value tree.
+func (r Result) Value() (*Value, error) {
+	var v Value
+	if err := r.Unmarshal(&v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// pathSegment is one dot separated part of a path.
+type pathSegment struct {
+	key string
+	// wildcard is set when key holds unescaped * or ? characters, in which
+	// case key keeps its escapes for wildcardMatch.
+	wildcard bool
+}
+
+// parsePath splits a path such as options.theme.colors.0 into segments.
+// A backslash escapes a dot, *, ? or backslash that is part of a key.
+func parsePath(path string) []pathSegment {
+	if path == `` {
+		return nil
+	}
+	var (
+		segs     []pathSegment
+		key      strings.Builder
+		pattern  strings.Builder
+		wildcard bool
+	)
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '\\' && i+1 < len(path):
+			i++
+			key.WriteByte(path[i])
+			pattern.WriteByte('\\')
+			pattern.WriteByte(path[i])
+			continue
+		case c == '.':
+			segs = append(segs, newPathSegment(key.String(), pattern.String(), wildcard))
+			key.Reset()
+			pattern.Reset()
+			wildcard = false
+			continue
+		case c == '*' || c == '?':
+			wildcard = true
+		}
+		key.WriteByte(c)
+		pattern.WriteByte(c)
+	}
+	return append(segs, newPathSegment(key.String(), pattern.String(), wildcard))
+}
+
+func newPathSegment(key, pattern string, wildcard bool) pathSegment {
+	if wildcard {
+		return pathSegment{key: pattern, wildcard: true}
+	}
+	return pathSegment{key: key}
+}
+
+func (s pathSegment) match(key []byte, object bool) bool {
+	name := string(key)
+	if s.exact(key) {
+		return true
+	}
+	if object {
+		name = unmangledName(name)
+	}
+	if s.wildcard {
+		return wildcardMatch(s.key, name)
+	}
+	return s.key == name
+}
+
+// exact reports whether a segment without wildcards names key exactly,
+// including any visibility mangling of a property. An exact match is
+// preferred over a property that only matches once unmangled, which tells
+// a private or protected property from a public one of the same name.
+func (s pathSegment) exact(key []byte) bool {
+	return !s.wildcard && s.key == string(key)
+}
+
+// wildcardMatch reports whether s matches pattern, in which * matches any
+// run of characters, ? matches a single character and a backslash escapes
+// the next character.
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
+func hasWildcard(segs []pathSegment) bool {
+	for _, s := range segs {
+		if s.wildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the value at path in data without decoding anything else.
+// Path segments are separated by dots and select array keys, integer
+// indices and object properties, which match without their visibility
+// mangling unless a property is named exactly, mangling included. A
+// segment containing * or ? matches every key it fits, and the result then
+// holds an array of all matches, keyed from 0 in input order, or nothing
+// when no key fits. Dots, * and ? within a key are escaped with a
+// backslash.
+//
+//	Get(data, `options.theme.colors.0`)
+//	Get(data, `users.*.email`)
+func Get(data []byte, path string) (Result, error) {
+	segs := parsePath(path)
+	var matches []Result
+	if err := getPath(data, 0, segs, &matches); err != nil {
+		return Result{Index: -1}, err
+	}
+
+	if len(matches) == 0 {
+		return Result{Index: -1}, nil
+	}
+	if !hasWildcard(segs) {
+		return matches[0], nil
+	}
+
+	raw := append([]byte(`a:`), strconv.Itoa(len(matches))...)
+	raw = append(raw, ':', '{')
+	for i, m := range matches {
+		raw = append(raw, 'i', ':')
+		raw = strconv.AppendInt(raw, int64(i), 10)
+		raw = append(raw, ';')
+		raw = append(raw, m.Raw...)
+	}
+	return Result{Raw: append(raw, '}'), Index: -1}, nil
+}
+
+// getPath appends the values matching segs within the value starting at
+// pos to matches.
+func getPath(data []byte, pos int, segs []pathSegment, matches *[]Result) error {
+	if len(segs) == 0 {
+		end, err := rawValueEnd(data, pos)
+		if err != nil {
+			return err
+		}
+		*matches = append(*matches, Result{Raw: data[pos:end:end], Index: pos})
+		return nil
+	}
+	if pos >= len(data) || (data[pos] != 'a' && data[pos] != 'O') {
+		return nil
+	}
+
+	h, err := rawContainer(data, pos)
+	if err != nil {
+		return err
+	}
+	object := data[pos] == 'O'
+	pos = h.body
+	next := -1
+	for i := 0; i < h.n; i++ {
+		key, valuePos, err := rawKey(data, pos)
+		if err != nil {
+			return err
+		}
+		if segs[0].exact(key) {
+			next = valuePos
+			break
+		}
+		if segs[0].match(key, object) {
+			if !segs[0].wildcard {
+				if next < 0 {
+					next = valuePos
+				}
+			} else if err := getPath(data, valuePos, segs[1:], matches); err != nil {
+				return err
+			}
+		}
+		if pos, err = rawValueEnd(data, valuePos); err != nil {
+			return err
+		}
+	}
+	if next < 0 {
+		return nil
+	}
+	return getPath(data, next, segs[1:], matches)
+}
+
+// pathLocation is where a path leads within serialized data.
+type pathLocation struct {
+	// header and end describe the deepest array or object reached, whose
+	// closing brace is at end.
+	header rawHeader
+	end    int
+	// found is set when every segment matched, in which case the last key
+	// starts at keyPos and its value spans valuePos to valueEnd.
+	found              bool
+	keyPos             int
+	valuePos, valueEnd int
+	// rest holds the segments that did not match.
+	rest []pathSegment
+}
+
+func locatePath(data []byte, segs []pathSegment) (pathLocation, error) {
+	var loc pathLocation
+	pos := 0
+	for i, seg := range segs {
+		if seg.wildcard {
+			return loc, fmt.Errorf(`phpserialize: wildcard path segment %q cannot be modified`, seg.key)
+		}
+		if pos >= len(data) || (data[pos] != 'a' && data[pos] != 'O') {
+			return loc, fmt.Errorf(`phpserialize: path segment %q is not within an array or object`, seg.key)
+		}
+		h, err := rawContainer(data, pos)
+		if err != nil {
+			return loc, err
+		}
+		object := data[pos] == 'O'
+
+		loc = pathLocation{header: h, rest: segs[i:]}
+		pos = h.body
+		next, exactFound := -1, false
+		for j := 0; j < h.n; j++ {
+			keyPos := pos
+			key, valuePos, err := rawKey(data, pos)
+			if err != nil {
+				return loc, err
+			}
+			if pos, err = rawValueEnd(data, valuePos); err != nil {
+				return loc, err
+			}
+			if exact := seg.exact(key); (next < 0 || (exact && !exactFound)) && seg.match(key, object) {
+				next, exactFound = valuePos, exact
+				loc.keyPos, loc.valuePos, loc.valueEnd = keyPos, valuePos, pos
+			}
+		}
+		loc.end = pos
+		if next < 0 {
+			return loc, nil
+		}
+		pos = next
+	}
+	loc.found = true
+	loc.rest = nil
+	return loc, nil
+}
+
+// rawEdit replaces data[start:end] with b.
+type rawEdit struct {
+	start, end int
+	b          []byte
+}
+
+// applyEdits returns a copy of data with edits, which are ordered and do
+// not overlap, applied.
+func applyEdits(data []byte, edits ...rawEdit) []byte {
+	size := len(data)
+	for _, e := range edits {
+		size += len(e.b) - (e.end - e.start)
+	}
+	out := make([]byte, 0, size)
+	pos := 0
+	for _, e := range edits {
+		out = append(out, data[pos:e.start]...)
+		out = append(out, e.b...)
+		pos = e.end
+	}
+	return append(out, data[pos:]...)
+}
+
+// appendKey appends key as PHP stores it, as an integer when it is one.
+func appendKey(b []byte, key string) []byte {
+	if n, ok := integerKey(key); ok {
+		b = append(b, 'i', ':')
+		b = strconv.AppendInt(b, n, 10)
+		return append(b, ';')
+	}
+	b = append(b, 's', ':')
+	b = strconv.AppendInt(b, int64(len(key)), 10)
+	b = append(b, ':', '"')
+	b = append(b, key...)
+	return append(b, '"', ';')
+}
+
+// Set returns a copy of data with the value at path replaced by value,
+// which is marshaled unless it is a RawMessage. Missing keys are added
+// to the deepest existing array or object, creating arrays for the
+// remaining segments, and the element count that changes is rewritten.
+// References following the value are renumbered to keep pointing at the
+// same values, and references within a RawMessage value count from its
+// own first value. All other bytes are left untouched. Wildcard segments
+// are not allowed, nor are references to the value being replaced.
+func Set(data []byte, path string, value interface{}) ([]byte, error) {
+	raw, ok := value.(RawMessage)
+	if !ok {
+		var err error
+		if raw, err = Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	segs := parsePath(path)
+	if len(segs) == 0 {
+		return append([]byte(nil), raw...), nil
+	}
+	loc, err := locatePath(data, segs)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := newRefTable(data)
+	if err != nil {
+		return nil, err
+	}
+	if loc.found {
+		raw, n, err := refs.shift(raw, loc.valuePos, 0)
+		if err != nil {
+			return nil, err
+		}
+		renumbered, err := refs.renumber(`Set`, loc.valuePos, loc.valueEnd, n)
+		if err != nil {
+			return nil, err
+		}
+		return applyEdits(data, append([]rawEdit{{loc.valuePos, loc.valueEnd, raw}}, renumbered...)...), nil
+	}
+
+	// Build the missing keys from the innermost out.
+	wrappers := len(loc.rest) - 1
+	raw, n, err := refs.shift(raw, loc.end, wrappers)
+	if err != nil {
+		return nil, err
+	}
+	for i := wrappers; i > 0; i-- {
+		b := append([]byte(`a:1:{`), appendKey(nil, loc.rest[i].key)...)
+		b = append(b, raw...)
+		raw = append(b, '}')
+	}
+	insert := append(appendKey(nil, loc.rest[0].key), raw...)
+	renumbered, err := refs.renumber(`Set`, loc.end, loc.end, n+wrappers)
+	if err != nil {
+		return nil, err
+	}
+
+	h := loc.header
+	return applyEdits(data, append([]rawEdit{
+		{h.countStart, h.countEnd, []byte(strconv.Itoa(h.n + 1))},
+		{loc.end, loc.end, insert},
+	}, renumbered...)...), nil
+}
+
+// Delete returns a copy of data without the key at path and its value,
+// rewriting the element count of the array or object that held it and
+// renumbering the references that follow. A reference to the deleted
+// value is an error. Data is returned unchanged when the path does not
+// exist.
+func Delete(data []byte, path string) ([]byte, error) {
+	segs := parsePath(path)
+	if len(segs) == 0 {
+		return nil, fmt.Errorf(`phpserialize: Delete(empty path)`)
+	}
+	loc, err := locatePath(data, segs)
+	if err != nil {
+		return nil, err
+	}
+	if !loc.found {
+		return append([]byte(nil), data...), nil
+	}
+
+	refs, err := newRefTable(data)
+	if err != nil {
+		return nil, err
+	}
+	renumbered, err := refs.renumber(`Delete`, loc.keyPos, loc.valueEnd, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	h := loc.header
+	return applyEdits(data, append([]rawEdit{
+		{h.countStart, h.countEnd, []byte(strconv.Itoa(h.n - 1))},
+		{loc.keyPos, loc.valueEnd, nil},
+	}, renumbered...)...), nil
+}
+
+// refTable holds the values of serialized data that take a reference
+// slot and the r: and R: references pointing at them, so references keep
+// pointing at the same values when Set and Delete add or remove some.
+type refTable struct {
+	slots []int
+	refs  []rawRef
+}
+
+func newRefTable(data []byte) (refTable, error) {
+	slots, refs, err := rawSlots(data)
+	return refTable{slots: slots, refs: refs}, err
+}
+
+// before returns the number of slots taken by values starting before pos.
+func (t refTable) before(pos int) int {
+	return sort.SearchInts(t.slots, pos)
+}
+
+// shift prepares value for insertion at pos behind skip values that are
+// added along with it. Its references count from its own first value, as
+// if it was serialized on its own, and are moved to the slots it is going
+// to take. It returns the value and its number of slots.
+func (t refTable) shift(value []byte, pos, skip int) ([]byte, int, error) {
+	slots, refs, err := rawSlots(value)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(refs) == 0 {
+		return value, len(slots), nil
+	}
+	by := t.before(pos) + skip
+	edits := make([]rawEdit, len(refs))
+	for i, ref := range refs {
+		edits[i] = rawEdit{ref.start, ref.end, []byte(strconv.Itoa(ref.n + by))}
+	}
+	return applyEdits(value, edits...), len(slots), nil
+}
+
+// renumber returns the edits that keep the references following
+// data[start:end] pointing at the same values once it is replaced by
+// values taking n slots. A reference to a value within it is an error.
+func (t refTable) renumber(op string, start, end, n int) ([]rawEdit, error) {
+	before := t.before(start)
+	removed := t.before(end) - before
+	var edits []rawEdit
+	for _, ref := range t.refs {
+		switch {
+		case ref.start < end || ref.n <= before:
+		case ref.n <= before+removed:
+			return nil, fmt.Errorf(`phpserialize: %s(reference at offset %d points into the edited value)`, op, ref.start-2)
+		case n != removed:
+			edits = append(edits, rawEdit{ref.start, ref.end, []byte(strconv.Itoa(ref.n - removed + n))})
+		}
+	}
+	return edits, nil
+}
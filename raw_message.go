@@ -0,0 +1,38 @@
+package phpserialize
+
+import "reflect"
+
+var rawMessageType = reflect.TypeOf((*RawMessage)(nil)).Elem()
+
+// RawMessage is a raw serialized value. It can be used to delay decoding
+// part of the input, or to pass a value through without decoding and
+// re-encoding it. The Encoder writes a nil or empty RawMessage as N;.
+type RawMessage []byte
+
+// DecodeRaw returns the serialized bytes of the next value without
+// interpreting them.
+func (d *Decoder) DecodeRaw() (RawMessage, error) {
+	return d.captureValue()
+}
+
+func decodeRawMessageValue(d *Decoder, v reflect.Value) error {
+	raw, err := d.DecodeRaw()
+	if err != nil {
+		return err
+	}
+	v.SetBytes(raw)
+	return nil
+}
+
+// EncodeRaw writes raw verbatim, or N; if raw is empty. The bytes are not
+// validated.
+func (e *Encoder) EncodeRaw(raw RawMessage) error {
+	if len(raw) == 0 {
+		return e.EncodeNil()
+	}
+	return e.write(raw)
+}
+
+func encodeRawMessageValue(e *Encoder, v reflect.Value) error {
+	return e.EncodeRaw(v.Bytes())
+}
@@ -0,0 +1,226 @@
+package phpserialize
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Repair returns a copy of data with wrong string length prefixes
+// corrected, the usual damage from charset conversions or search and
+// replace run over a database. When a string does not end where its
+// length says, it is taken to end at the first `";` followed by
+// something that can come next: another value, a closing brace or the end
+// of the input. Should the rest of the input then fail to parse, as when
+// the string holds a serialized value of its own, the next such `";` is
+// tried instead. Everything else must be well formed.
+func Repair(data []byte) ([]byte, error) {
+	r := repairer{data: data, out: make([]byte, 0, len(data))}
+	return r.run()
+}
+
+// UnmarshalRepair repairs data like Repair and decodes the result into v.
+// It returns the repaired bytes so they can be written back.
+func UnmarshalRepair(data []byte, v interface{}) ([]byte, error) {
+	repaired, err := Repair(data)
+	if err != nil {
+		return nil, err
+	}
+	return repaired, Unmarshal(repaired, v)
+}
+
+// repairer rewrites data into out one value at a time, keeping track of
+// the arrays and objects it is inside.
+type repairer struct {
+	data []byte
+	out  []byte
+	pos  int
+	// stack holds the number of keys and values left in each array or
+	// object being repaired.
+	stack []int
+	// choices records the strings whose end was guessed, latest last, so
+	// a later guess can be tried when the input that follows fails.
+	choices []repairChoice
+	retries int
+}
+
+// repairChoice is the state just before a string whose end was guessed
+// was written.
+type repairChoice struct {
+	// contents is the offset of the string contents and end that of the
+	// `"; taken to close them.
+	contents, end int
+	outLen        int
+	stack         []int
+}
+
+func (r *repairer) run() ([]byte, error) {
+	var first error
+	for {
+		done, err := r.step()
+		if err == nil {
+			if done {
+				return r.out, nil
+			}
+			continue
+		}
+		if first == nil {
+			first = err
+		}
+		// Retries are limited to one per input byte, which keeps the
+		// search from going exponential. Each may parse the rest of the
+		// input again, so repair takes O(n²) time at worst, though a
+		// wrong guess usually fails within a few values.
+		if r.retries++; r.retries > len(r.data) || !r.backtrack() {
+			return nil, first
+		}
+	}
+}
+
+// step repairs the next value, or the closing brace of the current array
+// or object, and reports whether the input is done.
+func (r *repairer) step() (bool, error) {
+	data, pos := r.data, r.pos
+	if len(r.stack) == 0 {
+		if pos == len(data) {
+			return true, nil
+		}
+	} else if top := &r.stack[len(r.stack)-1]; *top == 0 {
+		end, err := rawExpect(data, pos, `}`)
+		if err != nil {
+			return false, err
+		}
+		r.out = append(r.out, '}')
+		r.pos = end
+		r.stack = r.stack[:len(r.stack)-1]
+		return false, nil
+	} else {
+		*top--
+	}
+	if pos >= len(data) {
+		return false, syntaxErrorf(int64(pos), `unexpected end of input`)
+	}
+
+	switch data[pos] {
+	case 's':
+		return false, r.repairString()
+	case 'a', 'O':
+		if len(r.stack) >= DefaultMaxDepth {
+			return false, &LimitError{Limit: `depth`, Max: DefaultMaxDepth, Offset: int64(pos)}
+		}
+		h, err := rawContainer(data, pos)
+		if err != nil {
+			return false, err
+		}
+		r.out = append(r.out, data[pos:h.body]...)
+		r.pos = h.body
+		r.stack = append(r.stack, 2*h.n)
+		return false, nil
+	}
+
+	end, err := rawValueEnd(data, pos)
+	if err != nil {
+		return false, err
+	}
+	r.out = append(r.out, data[pos:end]...)
+	r.pos = end
+	return false, nil
+}
+
+// repairString reads the s: value at the current offset, whose length
+// prefix may be wrong, and appends it with the right one.
+func (r *repairer) repairString() error {
+	data := r.data
+	pos, err := rawExpect(data, r.pos, `s:`)
+	if err != nil {
+		return err
+	}
+	n, pos, err := rawLen(data, pos, ':')
+	if err != nil {
+		return err
+	}
+	if pos, err = rawExpect(data, pos, `"`); err != nil {
+		return err
+	}
+
+	if end := pos + n; end+2 <= len(data) && data[end] == '"' && data[end+1] == ';' && plausibleNext(data, end+2) {
+		r.appendString(pos, end)
+		return nil
+	}
+	end := nextStringEnd(data, pos)
+	if end < 0 {
+		return syntaxErrorf(int64(pos), `unterminated string`)
+	}
+	r.choices = append(r.choices, repairChoice{
+		contents: pos,
+		end:      end,
+		outLen:   len(r.out),
+		stack:    append([]int(nil), r.stack...),
+	})
+	r.appendString(pos, end)
+	return nil
+}
+
+// backtrack resumes from the latest string whose end can be guessed
+// differently, reporting false when there is none.
+func (r *repairer) backtrack() bool {
+	for len(r.choices) > 0 {
+		c := &r.choices[len(r.choices)-1]
+		if end := nextStringEnd(r.data, c.end+1); end >= 0 {
+			c.end = end
+			r.out = r.out[:c.outLen]
+			r.stack = append(r.stack[:0], c.stack...)
+			r.appendString(c.contents, end)
+			return true
+		}
+		r.choices = r.choices[:len(r.choices)-1]
+	}
+	return false
+}
+
+// appendString appends the string whose contents span data[start:end],
+// closed by the `"; at end.
+func (r *repairer) appendString(start, end int) {
+	r.out = appendString(r.out, r.data[start:end])
+	r.pos = end + 2
+}
+
+// nextStringEnd returns the offset of the first `"; from pos on that a
+// plausible value follows, or -1 if there is none.
+func nextStringEnd(data []byte, pos int) int {
+	for pos <= len(data) {
+		j := bytes.Index(data[pos:], []byte(`";`))
+		if j < 0 {
+			return -1
+		}
+		end := pos + j
+		if plausibleNext(data, end+2) {
+			return end
+		}
+		pos = end + 1
+	}
+	return -1
+}
+
+// plausibleNext reports whether a value can end just before pos: pos is
+// the end of the input, a closing brace or the start of another value.
+func plausibleNext(data []byte, pos int) bool {
+	if pos == len(data) || data[pos] == '}' {
+		return true
+	}
+	if pos+1 >= len(data) {
+		return false
+	}
+	if data[pos] == 'N' {
+		return data[pos+1] == ';'
+	}
+	return data[pos+1] == ':' && strings.IndexByte(`sibdaOCrR`, data[pos]) >= 0
+}
+
+func appendString(b, s []byte) []byte {
+	b = append(b, 's', ':')
+	b = strconv.AppendInt(b, int64(len(s)), 10)
+	b = append(b, ':', '"')
+	b = append(b, s...)
+	return append(b, '"', ';')
+}
@@ -0,0 +1,114 @@
+package phpserialize
+
+import "bytes"
+
+// ReplaceOptions configures Replace.
+type ReplaceOptions struct {
+	// Keys also replaces within string array keys and property names.
+	Keys bool
+}
+
+// Replace returns a copy of data with every occurrence of from in string
+// values replaced by to and their length prefixes recomputed, as needed
+// when moving a site to another domain. Strings that hold serialized data
+// themselves are replaced within recursively. Integers, floats, class
+// names and the payloads of C: objects are left untouched.
+func Replace(data []byte, from, to string, opts ReplaceOptions) ([]byte, error) {
+	if from == `` || !bytes.Contains(data, []byte(from)) {
+		return append([]byte(nil), data...), nil
+	}
+	r := replacer{from: []byte(from), to: []byte(to), opts: opts}
+	return r.replaceAll(data, 0)
+}
+
+type replacer struct {
+	from, to []byte
+	opts     ReplaceOptions
+}
+
+// replaceAll replaces within every value of data, which holds one or more
+// serialized values.
+func (r *replacer) replaceAll(data []byte, depth int) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	pos := 0
+	var err error
+	for pos < len(data) {
+		if out, pos, err = r.replaceValue(data, pos, out, false, depth); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// replaceValue appends the value starting at pos to out with replacements
+// made.
+func (r *replacer) replaceValue(data []byte, pos int, out []byte, key bool, depth int) ([]byte, int, error) {
+	if pos >= len(data) {
+		return nil, 0, syntaxErrorf(int64(pos), `unexpected end of input`)
+	}
+
+	switch data[pos] {
+	case 's':
+		s, end, err := rawString(data, pos)
+		if err != nil {
+			return nil, 0, err
+		}
+		if key && !r.opts.Keys || !bytes.Contains(s, r.from) {
+			return append(out, data[pos:end]...), end, nil
+		}
+		return appendString(out, r.replaceString(s, depth)), end, nil
+	case 'a', 'O':
+		if depth >= DefaultMaxDepth {
+			return nil, 0, &LimitError{Limit: `depth`, Max: DefaultMaxDepth, Offset: int64(pos)}
+		}
+		h, err := rawContainer(data, pos)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, data[pos:h.body]...)
+		pos = h.body
+		for i := 0; i < 2*h.n; i++ {
+			if out, pos, err = r.replaceValue(data, pos, out, i%2 == 0, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		if pos, err = rawExpect(data, pos, `}`); err != nil {
+			return nil, 0, err
+		}
+		return append(out, '}'), pos, nil
+	}
+
+	end, err := rawValueEnd(data, pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(out, data[pos:end]...), end, nil
+}
+
+// replaceString replaces within the contents of a string, treating them as
+// serialized data when they parse as such.
+func (r *replacer) replaceString(s []byte, depth int) []byte {
+	if isSerializedSequence(s) {
+		if nested, err := r.replaceAll(s, depth+1); err == nil {
+			return nested
+		}
+	}
+	return bytes.ReplaceAll(s, r.from, r.to)
+}
+
+// isSerializedSequence reports whether s consists of one or more complete
+// serialized values.
+func isSerializedSequence(s []byte) bool {
+	if len(s) < 2 || s[1] != ':' && s[1] != ';' {
+		return false
+	}
+	pos := 0
+	for pos < len(s) {
+		end, err := rawValueEnd(s, pos)
+		if err != nil {
+			return false
+		}
+		pos = end
+	}
+	return true
+}
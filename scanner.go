@@ -0,0 +1,283 @@
+package phpserialize
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// TokenKind identifies the kind of a Token returned by Scanner.Next.
+type TokenKind int
+
+const (
+	// TokenNull is N;
+	TokenNull TokenKind = iota + 1
+	// TokenBool is b:0; or b:1;, with the value in Token.Bool.
+	TokenBool
+	// TokenInt is i:n;, with the value in Token.Int.
+	TokenInt
+	// TokenFloat is d:f;, with the value in Token.Float.
+	TokenFloat
+	// TokenString is s:n:"...";, with the value in Token.String.
+	TokenString
+	// TokenArrayStart is a:n:{, with the number of elements in Token.Len.
+	TokenArrayStart
+	// TokenObjectStart is O:n:"Class":n:{, with the class in Token.Class and
+	// the number of properties in Token.Len.
+	TokenObjectStart
+	// TokenKey is an array key or property name. Integer keys are held in
+	// Token.Int and string keys in Token.String, with Token.Code telling
+	// them apart.
+	TokenKey
+	// TokenEnd is the closing brace of an array or object.
+	TokenEnd
+	// TokenRef is r:n; or R:n;, with the referenced slot in Token.Int.
+	TokenRef
+	// TokenCustom is a whole C:n:"Class":n:{...} object, with the class in
+	// Token.Class and its payload in Token.Data.
+	TokenCustom
+)
+
+var tokenKindNames = [...]string{
+	TokenNull:        `Null`,
+	TokenBool:        `Bool`,
+	TokenInt:         `Int`,
+	TokenFloat:       `Float`,
+	TokenString:      `String`,
+	TokenArrayStart:  `ArrayStart`,
+	TokenObjectStart: `ObjectStart`,
+	TokenKey:         `Key`,
+	TokenEnd:         `End`,
+	TokenRef:         `Ref`,
+	TokenCustom:      `Custom`,
+}
+
+func (k TokenKind) String() string {
+	if k > 0 && int(k) < len(tokenKindNames) {
+		return tokenKindNames[k]
+	}
+	return fmt.Sprintf(`TokenKind(%d)`, int(k))
+}
+
+// Token is a single element of the serialized input. Only the fields
+// documented for its Kind are set.
+type Token struct {
+	Kind TokenKind
+	// Code is the serialized type code of the token, e.g. 'i' or 's' for a
+	// key and 'r' or 'R' for a reference.
+	Code byte
+	// Offset is the input offset at which the token starts.
+	Offset int64
+
+	Bool   bool
+	Int    int64
+	Float  float64
+	String string
+	Class  string
+	Len    int
+	Data   []byte
+}
+
+// scanFrame tracks an array or object being scanned.
+type scanFrame struct {
+	remaining int
+	key       bool
+}
+
+// Scanner reads serialized input one token at a time without building Go
+// values for arrays and objects, so inputs far larger than memory can be
+// processed. Several values written one after another are scanned in turn.
+type Scanner struct {
+	d     *Decoder
+	stack []scanFrame
+}
+
+// NewScanner returns a new scanner that reads from r.
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{d: NewDecoder(r)}
+}
+
+// Decoder returns the Decoder the scanner reads through, whose limits and
+// class restrictions apply to the scanned input.
+func (s *Scanner) Decoder() *Decoder {
+	return s.d
+}
+
+// Depth returns the number of arrays and objects the scanner is inside.
+func (s *Scanner) Depth() int {
+	return len(s.stack)
+}
+
+// Next returns the next token. It returns io.EOF once the input ends after
+// a complete value and io.ErrUnexpectedEOF if it ends inside one, including
+// within a token.
+func (s *Scanner) Next() (Token, error) {
+	start := s.d.offset
+	tok, err := s.next()
+	if err == io.EOF && (len(s.stack) > 0 || s.d.offset > start) {
+		err = io.ErrUnexpectedEOF
+	}
+	return tok, err
+}
+
+func (s *Scanner) next() (Token, error) {
+	if len(s.stack) > 0 {
+		top := &s.stack[len(s.stack)-1]
+		if top.key {
+			if top.remaining == 0 {
+				return s.end()
+			}
+			return s.key(top)
+		}
+	}
+	return s.value()
+}
+
+// Skip discards the next value, including any elements of an array or
+// object, instead of returning its tokens. It must only be called when the
+// next token is a value rather than a key or the end of an array.
+func (s *Scanner) Skip() error {
+	if len(s.stack) > 0 && s.stack[len(s.stack)-1].key {
+		return fmt.Errorf(`phpserialize: Skip(next token is not a value)`)
+	}
+	start := s.d.offset
+	err := s.d.Skip()
+	if err == io.EOF && (len(s.stack) > 0 || s.d.offset > start) {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	s.consumed()
+	return nil
+}
+
+func (s *Scanner) end() (Token, error) {
+	tok := Token{Kind: TokenEnd, Code: '}', Offset: s.d.offset}
+	if err := s.d.DecodeArrayEnd(); err != nil {
+		return tok, err
+	}
+	s.stack = s.stack[:len(s.stack)-1]
+	return tok, nil
+}
+
+func (s *Scanner) key(top *scanFrame) (Token, error) {
+	tok := Token{Kind: TokenKey, Offset: s.d.offset}
+	code, err := s.d.PeekCode()
+	if err != nil {
+		return tok, err
+	}
+	tok.Code = code
+	switch code {
+	case 'i':
+		tok.Int, err = s.d.DecodeInt64()
+	case 's':
+		tok.String, err = s.d.DecodeString()
+	default:
+		return tok, syntaxErrorf(tok.Offset, `invalid array key '%c'`, code)
+	}
+	if err != nil {
+		return tok, err
+	}
+	top.key = false
+	top.remaining--
+	return tok, nil
+}
+
+func (s *Scanner) value() (Token, error) {
+	tok := Token{Offset: s.d.offset}
+	code, err := s.d.PeekCode()
+	if err != nil {
+		return tok, err
+	}
+	tok.Code = code
+
+	switch code {
+	case 'N':
+		tok.Kind = TokenNull
+		err = s.d.DecodeNil()
+	case 'b':
+		tok.Kind = TokenBool
+		tok.Bool, err = s.d.DecodeBool()
+	case 'i':
+		tok.Kind = TokenInt
+		tok.Int, err = s.d.DecodeInt64()
+	case 'd':
+		tok.Kind = TokenFloat
+		tok.Float, err = s.d.DecodeFloat64()
+	case 's':
+		tok.Kind = TokenString
+		tok.String, err = s.d.DecodeString()
+	case 'r', 'R':
+		tok.Kind = TokenRef
+		tok.Int, err = s.readRef(code)
+	case 'a':
+		tok.Kind = TokenArrayStart
+		if tok.Len, err = s.d.DecodeArrayLen(); err == nil {
+			s.consumed()
+			s.stack = append(s.stack, scanFrame{remaining: tok.Len, key: true})
+		}
+		return tok, err
+	case 'O':
+		tok.Kind = TokenObjectStart
+		if tok.Class, tok.Len, err = s.d.readObjectHeader(); err == nil {
+			err = s.d.checkClass(tok.Class, tok.Offset, true)
+		}
+		if err == nil {
+			s.consumed()
+			s.stack = append(s.stack, scanFrame{remaining: tok.Len, key: true})
+		}
+		return tok, err
+	case 'C':
+		tok.Kind = TokenCustom
+		err = s.readCustom(&tok)
+	default:
+		return tok, syntaxErrorf(tok.Offset, `unexpected code '%c'`, code)
+	}
+
+	if err != nil {
+		return tok, err
+	}
+	s.consumed()
+	return tok, nil
+}
+
+// consumed records that a whole value of the current array or object has
+// been read, so a key or the closing brace follows.
+func (s *Scanner) consumed() {
+	if len(s.stack) > 0 {
+		s.stack[len(s.stack)-1].key = true
+	}
+}
+
+func (s *Scanner) readRef(code byte) (int64, error) {
+	offset := s.d.offset
+	if err := s.d.skipExpected(code, ':'); err != nil {
+		return 0, err
+	}
+	b, err := s.d.readUntil(';')
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, syntaxErrorf(offset, `invalid reference %q`, b)
+	}
+	return n, nil
+}
+
+func (s *Scanner) readCustom(tok *Token) error {
+	class, n, err := s.d.readCustomHeader()
+	if err != nil {
+		return err
+	}
+	if err := s.d.checkClass(class, tok.Offset, true); err != nil {
+		return err
+	}
+	tok.Class = class
+	tok.Len = n
+	if tok.Data, err = s.d.readBytes(n); err != nil {
+		return err
+	}
+	return s.d.skipExpected('}')
+}
@@ -0,0 +1,225 @@
+package phpserialize
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SetSortMapKeys sets whether map keys are written in the order PHP's
+// ksort puts them in, so that encoding a map always gives the same bytes.
+// It is on by default.
+func (e *Encoder) SetSortMapKeys(on bool) {
+	if on {
+		e.flags &= ^unsortedMapKeysFlag
+	} else {
+		e.flags |= unsortedMapKeysFlag
+	}
+}
+
+// phpKey is a key as PHP sees an array key: an integer or a string.
+type phpKey struct {
+	isStr bool
+	str   string
+	num   int64
+}
+
+func mapKey(v reflect.Value) phpKey {
+	var k phpKey
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		k.num = v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		k.num = int64(v.Uint())
+	case reflect.Bool:
+		if v.Bool() {
+			k.num = 1
+		}
+	case reflect.Float32, reflect.Float64:
+		k.num = int64(v.Float())
+	case reflect.String:
+		k.isStr, k.str = true, v.String()
+	default:
+		k.isStr, k.str = true, fmt.Sprint(v.Interface())
+	}
+	return k
+}
+
+// sortMapKeys sorts keys like PHP's ksort with its default flags.
+func sortMapKeys(keys []reflect.Value) {
+	ks := make([]phpKey, len(keys))
+	for i, key := range keys {
+		ks[i] = mapKey(key)
+	}
+	sorted := make([]reflect.Value, len(keys))
+	for i, j := range ksortOrder(ks) {
+		sorted[i] = keys[j]
+	}
+	copy(keys, sorted)
+}
+
+// sortKey is a key being sorted, with a string key parsed as a number
+// once rather than on every comparison.
+type sortKey struct {
+	phpKey
+	// numeric is set for a numeric string key, whose value is in n when
+	// isInt is set and in f either way.
+	numeric, isInt bool
+	n              int64
+	f              float64
+}
+
+func newSortKey(k phpKey) sortKey {
+	s := sortKey{phpKey: k}
+	if k.isStr {
+		s.n, s.f, s.isInt, s.numeric = parseNumeric(k.str)
+	}
+	return s
+}
+
+// ksortOrder returns the indexes of keys in the order PHP's ksort puts
+// them in.
+func ksortOrder(keys []phpKey) []int {
+	order := make([]int, len(keys))
+	sks := make([]sortKey, len(keys))
+	for i := range order {
+		order[i] = i
+		sks[i] = newSortKey(keys[i])
+	}
+
+	// PHP's comparison of mixed keys is not transitive, so its result
+	// depends on the order it starts from. Start from a total order rather
+	// than the random order of Go maps, which also orders keys PHP sees as
+	// equal.
+	sort.Slice(order, func(i, j int) bool {
+		a, b := keys[order[i]], keys[order[j]]
+		if a.isStr != b.isStr {
+			return !a.isStr
+		}
+		if a.isStr {
+			return a.str < b.str
+		}
+		return a.num < b.num
+	})
+	sort.SliceStable(order, func(i, j int) bool {
+		return compareKeys(sks[order[i]], sks[order[j]]) < 0
+	})
+	return order
+}
+
+// compareKeys compares array keys the way ksort does since PHP 8.
+func compareKeys(a, b sortKey) int {
+	switch {
+	case !a.isStr && !b.isStr:
+		return compareInts(a.num, b.num)
+	case a.isStr && b.isStr:
+		return smartStrcmp(a, b)
+	case a.isStr:
+		return -compareIntToString(b.num, a)
+	}
+	return compareIntToString(a.num, b)
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// smartStrcmp compares string keys numerically when both are numeric and
+// byte by byte otherwise.
+func smartStrcmp(a, b sortKey) int {
+	if a.numeric && b.numeric {
+		if a.isInt && b.isInt {
+			return compareInts(a.n, b.n)
+		}
+		return compareFloats(a.f, b.f)
+	}
+	return strings.Compare(a.str, b.str)
+}
+
+// compareIntToString compares an integer key to a string key: numerically
+// when the string is numeric, else as the integer's decimal string.
+func compareIntToString(n int64, s sortKey) int {
+	switch {
+	case s.numeric && s.isInt:
+		return compareInts(n, s.n)
+	case s.numeric:
+		return compareFloats(float64(n), s.f)
+	}
+	return strings.Compare(strconv.FormatInt(n, 10), s.str)
+}
+
+// parseNumeric parses s if it is a numeric string as PHP 8 defines them:
+// an optionally signed decimal integer or float with an optional exponent,
+// surrounded by optional whitespace. Integers that overflow are floats.
+func parseNumeric(s string) (n int64, f float64, isInt, ok bool) {
+	s = strings.Trim(s, " \t\n\r\v\f")
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	intDigits := countDigits(s[i:])
+	i += intDigits
+	fracDigits := 0
+	isInt = true
+	if i < len(s) && s[i] == '.' {
+		isInt = false
+		fracDigits = countDigits(s[i+1:])
+		i += 1 + fracDigits
+	}
+	if intDigits == 0 && fracDigits == 0 {
+		return 0, 0, false, false
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if expDigits := countDigits(s[j:]); expDigits > 0 {
+			isInt = false
+			i = j + expDigits
+		}
+	}
+	if i != len(s) {
+		return 0, 0, false, false
+	}
+
+	if isInt {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, float64(n), true, true
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && f == 0 {
+		return 0, 0, false, false
+	}
+	return 0, f, false, true
+}
+
+func countDigits(s string) int {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return i
+}
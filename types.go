@@ -1,7 +1,6 @@
 package phpserialize
 
 import (
-	"fmt"
 	"github.com/vmihailenco/tagparser"
 	"reflect"
 	"sync"
@@ -45,8 +44,9 @@ func (m *structCache) Fields(typ reflect.Type, tag string) *fields {
 }
 
 type field struct {
-	name  string
-	index []int
+	name   string
+	goName string
+	index  []int
 	// omitEmpty bool
 	encoder encoderFunc
 	decoder decoderFunc
@@ -82,13 +82,28 @@ func getFields(typ reflect.Type, fallbackTag string) *fields {
 		}
 
 		field := &field{
-			name:  tag.Name,
-			index: f.Index,
+			name:   tag.Name,
+			goName: f.Name,
+			index:  f.Index,
 			// omitEmpty: omitEmpty || tag.HasOption("omitempty"),
 		}
 
 		field.encoder = getEncoder(f.Type)
 		field.decoder = getDecoder(f.Type)
+		switch indirectType(f.Type) {
+		case timeType:
+			for _, opt := range timeFormatOptions {
+				if tag.HasOption(opt.option) {
+					field.encoder = timeFieldEncoder(f.Type, opt.format)
+					break
+				}
+			}
+		case durationType:
+			if tag.HasOption(`seconds`) {
+				field.encoder = durationSecondsEncoder(f.Type)
+				field.decoder = durationSecondsDecoder(f.Type)
+			}
+		}
 
 		if field.name == "" {
 			field.name = f.Name
@@ -100,6 +115,13 @@ func getFields(typ reflect.Type, fallbackTag string) *fields {
 	return fs
 }
 
+func indirectType(typ reflect.Type) reflect.Type {
+	if typ.Kind() == reflect.Ptr {
+		return typ.Elem()
+	}
+	return typ
+}
+
 type fields struct {
 	Type reflect.Type
 	Map  map[string]*field
@@ -121,9 +143,9 @@ func (fs *fields) Add(field *field) {
 func (f *field) DecodeValue(d *Decoder, strct reflect.Value) error {
 	v := fieldByIndexAlloc(strct, f.index)
 	if f.decoder == nil {
-		return fmt.Errorf(`phpserialize: could not find decoder for field %s`, f.name)
+		return withField(d.unsupportedTypeError(v.Type()), f.goName)
 	}
-	return f.decoder(d, v)
+	return withField(f.decoder(d, v), f.goName)
 }
 
 func fieldByIndex(v reflect.Value, index []int) (_ reflect.Value, ok bool) {
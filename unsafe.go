@@ -0,0 +1,23 @@
+package phpserialize
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// bytesToString returns a string sharing memory with b.
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// stringToBytes returns a byte slice sharing memory with s, which must not
+// be written to.
+func stringToBytes(s string) []byte {
+	var b []byte
+	bh := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+	sh := (*reflect.StringHeader)(unsafe.Pointer(&s))
+	bh.Data = sh.Data
+	bh.Len = sh.Len
+	bh.Cap = sh.Len
+	return b
+}
@@ -0,0 +1,172 @@
+package phpserialize
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// IsSerialized reports whether data looks like a serialized value, using
+// the same heuristic as WordPress's is_serialized in its default strict
+// mode. It only checks the shape of the start and end of data; use
+// Validate for a full check.
+func IsSerialized(data []byte) bool {
+	data = bytes.Trim(data, " \t\n\r\x00\x0B")
+	if string(data) == `N;` {
+		return true
+	}
+	if len(data) < 4 || data[1] != ':' {
+		return false
+	}
+	if last := data[len(data)-1]; last != ';' && last != '}' {
+		return false
+	}
+
+	switch token := data[0]; token {
+	case 's':
+		if data[len(data)-2] != '"' {
+			return false
+		}
+		fallthrough
+	case 'a', 'O', 'E':
+		// ^token:[0-9]+:
+		i := 2
+		for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+			i++
+		}
+		return i > 2 && i < len(data) && data[i] == ':'
+	case 'b', 'i', 'd':
+		// ^token:[0-9.E+-]+;$
+		body := data[2 : len(data)-1]
+		if len(body) == 0 {
+			return false
+		}
+		for _, c := range body {
+			if (c < '0' || c > '9') && c != '.' && c != 'E' && c != '+' && c != '-' {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Unwrap returns a copy of data in which every string value holding a
+// single serialized value, as written by WordPress's maybe_serialize when
+// given data that was already serialized, is replaced by that value.
+// Unwrapping is repeated for strings nested within strings. Array keys
+// and property names are left untouched. References are renumbered to
+// keep pointing at the same values, both those following an unwrapped
+// string and those within it.
+func Unwrap(data []byte) ([]byte, error) {
+	u := unwrapper{out: make([]byte, 0, len(data))}
+	pos := 0
+	var err error
+	for pos < len(data) {
+		u.slots = 0
+		if pos, err = u.value(data, pos, new([]int), 0); err != nil {
+			return nil, err
+		}
+	}
+	return u.out, nil
+}
+
+// UnmarshalUnwrap unwraps data like Unwrap and decodes the result into v.
+func UnmarshalUnwrap(data []byte, v interface{}) error {
+	unwrapped, err := Unwrap(data)
+	if err != nil {
+		return err
+	}
+	return Unmarshal(unwrapped, v)
+}
+
+// unwrapper writes unwrapped values to out.
+type unwrapper struct {
+	out []byte
+	// slots counts the values written to out for the current top-level
+	// value that take a slot references point into.
+	slots int
+}
+
+// value appends the value starting at pos to out with serialized strings
+// unwrapped. slots maps the slots of the serialized value data holds,
+// counting from 1, to those of out, and gains the slots the value takes.
+func (u *unwrapper) value(data []byte, pos int, slots *[]int, depth int) (int, error) {
+	if pos >= len(data) {
+		return 0, syntaxErrorf(int64(pos), `unexpected end of input`)
+	}
+	if depth >= DefaultMaxDepth {
+		return 0, &LimitError{Limit: `depth`, Max: DefaultMaxDepth, Offset: int64(pos)}
+	}
+
+	switch data[pos] {
+	case 's':
+		s, end, err := rawString(data, pos)
+		if err != nil {
+			return 0, err
+		}
+		if IsSerialized(s) {
+			if n, err := rawValueEnd(s, 0); err == nil && n == len(s) {
+				// The string's slot is taken by the value it holds, whose
+				// own references count from it.
+				*slots = append(*slots, u.slots+1)
+				if _, err = u.value(s, 0, new([]int), depth+1); err != nil {
+					return 0, err
+				}
+				return end, nil
+			}
+		}
+		u.take(slots)
+		u.out = append(u.out, data[pos:end]...)
+		return end, nil
+	case 'a', 'O':
+		h, err := rawContainer(data, pos)
+		if err != nil {
+			return 0, err
+		}
+		u.take(slots)
+		u.out = append(u.out, data[pos:h.body]...)
+		pos = h.body
+		for i := 0; i < h.n; i++ {
+			_, valuePos, err := rawKey(data, pos)
+			if err != nil {
+				return 0, err
+			}
+			u.out = append(u.out, data[pos:valuePos]...)
+			if pos, err = u.value(data, valuePos, slots, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		if pos, err = rawExpect(data, pos, `}`); err != nil {
+			return 0, err
+		}
+		u.out = append(u.out, '}')
+		return pos, nil
+	}
+
+	end, err := rawValueEnd(data, pos)
+	if err != nil {
+		return 0, err
+	}
+	if code := data[pos]; code == 'r' || code == 'R' {
+		n, err := strconv.Atoi(string(data[pos+2 : end-1]))
+		if err != nil || n < 1 || n > len(*slots) {
+			return 0, syntaxErrorf(int64(pos), `reference %s out of range`, data[pos+2:end-1])
+		}
+		u.out = append(u.out, code, ':')
+		u.out = strconv.AppendInt(u.out, int64((*slots)[n-1]), 10)
+		u.out = append(u.out, ';')
+		if code == 'r' {
+			u.take(slots)
+		}
+		return end, nil
+	}
+	u.take(slots)
+	u.out = append(u.out, data[pos:end]...)
+	return end, nil
+}
+
+// take records that the next value written to out takes a slot.
+func (u *unwrapper) take(slots *[]int) {
+	u.slots++
+	*slots = append(*slots, u.slots)
+}
@@ -0,0 +1,323 @@
+package phpserialize
+
+// Valid reports whether data is a single well formed serialized value.
+func Valid(data []byte) bool {
+	return Validate(data) == nil
+}
+
+// Validate checks that data is a single well formed serialized value: that
+// every length, element count and terminator is right, that array keys are
+// integers or strings, that class names are valid and that references
+// point at a value that came before them, with nothing following the
+// value. It does not allocate unless data is invalid.
+func Validate(data []byte) error {
+	v := validator{data: data}
+	if err := v.value(0); err != nil {
+		return err
+	}
+	if v.pos != len(data) {
+		return syntaxErrorf(int64(v.pos), `unexpected data after value`)
+	}
+	return nil
+}
+
+type validator struct {
+	data []byte
+	pos  int
+	// slots counts the values a reference may point at, like the variable
+	// table PHP's unserialize keeps.
+	slots int
+}
+
+func (v *validator) value(depth int) error {
+	if v.pos >= len(v.data) {
+		return v.eof()
+	}
+
+	code := v.data[v.pos]
+	if code != 'r' && code != 'R' {
+		v.slots++
+	}
+	switch code {
+	case 'N':
+		return v.expect('N', ';')
+	case 'b':
+		if err := v.expect('b', ':'); err != nil {
+			return err
+		}
+		if v.pos < len(v.data) && (v.data[v.pos] == '0' || v.data[v.pos] == '1') {
+			v.pos++
+		} else {
+			return v.syntaxError(`invalid bool`)
+		}
+		return v.expect(';')
+	case 'i':
+		if err := v.expect('i', ':'); err != nil {
+			return err
+		}
+		return v.integer()
+	case 'd':
+		if err := v.expect('d', ':'); err != nil {
+			return err
+		}
+		return v.float()
+	case 's':
+		return v.str()
+	case 'r', 'R':
+		start := v.pos
+		if err := v.expect(code, ':'); err != nil {
+			return err
+		}
+		n, err := v.uint(';')
+		if err != nil {
+			return err
+		}
+		// A reference can only point at a value before it. An r: reference
+		// takes a slot itself once checked, an R: reference none.
+		if n < 1 || n > v.slots {
+			return syntaxErrorf(int64(start), `reference %d out of range`, n)
+		}
+		if code == 'r' {
+			v.slots++
+		}
+		return nil
+	case 'a':
+		if err := v.expect('a', ':'); err != nil {
+			return err
+		}
+		return v.elements(depth)
+	case 'O':
+		if err := v.expect('O', ':'); err != nil {
+			return err
+		}
+		if err := v.class(); err != nil {
+			return err
+		}
+		return v.elements(depth)
+	case 'C':
+		if err := v.expect('C', ':'); err != nil {
+			return err
+		}
+		if err := v.class(); err != nil {
+			return err
+		}
+		n, err := v.uint(':')
+		if err != nil {
+			return err
+		}
+		if err := v.expect('{'); err != nil {
+			return err
+		}
+		if n > len(v.data)-v.pos {
+			return v.syntaxError(`object length exceeds input`)
+		}
+		v.pos += n
+		return v.expect('}')
+	}
+
+	return v.syntaxError(`unexpected code`)
+}
+
+// elements checks the count, keys, values and braces of an array or object
+// following its a: or class prefix.
+func (v *validator) elements(depth int) error {
+	if depth >= DefaultMaxDepth {
+		return &LimitError{Limit: `depth`, Max: DefaultMaxDepth, Offset: int64(v.pos)}
+	}
+	n, err := v.uint(':')
+	if err != nil {
+		return err
+	}
+	if err := v.expect('{'); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := v.key(); err != nil {
+			return err
+		}
+		if err := v.value(depth + 1); err != nil {
+			return err
+		}
+	}
+	return v.expect('}')
+}
+
+func (v *validator) key() error {
+	if v.pos >= len(v.data) {
+		return v.eof()
+	}
+	switch v.data[v.pos] {
+	case 'i':
+		v.pos++
+		if err := v.expect(':'); err != nil {
+			return err
+		}
+		return v.integer()
+	case 's':
+		return v.str()
+	}
+	return v.syntaxError(`invalid array key`)
+}
+
+func (v *validator) str() error {
+	if err := v.expect('s', ':'); err != nil {
+		return err
+	}
+	n, err := v.uint(':')
+	if err != nil {
+		return err
+	}
+	if err := v.expect('"'); err != nil {
+		return err
+	}
+	if n > len(v.data)-v.pos {
+		return v.syntaxError(`string length exceeds input`)
+	}
+	v.pos += n
+	return v.expect('"', ';')
+}
+
+// class checks a length prefixed class name and the colon after it.
+func (v *validator) class() error {
+	n, err := v.uint(':')
+	if err != nil {
+		return err
+	}
+	if err := v.expect('"'); err != nil {
+		return err
+	}
+	if n == 0 || n > len(v.data)-v.pos {
+		return v.syntaxError(`invalid class name length`)
+	}
+	for _, c := range v.data[v.pos : v.pos+n] {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '\\' || c >= 0x7f) {
+			return v.syntaxError(`invalid class name`)
+		}
+	}
+	v.pos += n
+	return v.expect('"', ':')
+}
+
+// uint checks an unsigned decimal that fits an int followed by sep and
+// returns its value.
+func (v *validator) uint(sep byte) (int, error) {
+	start := v.pos
+	n := 0
+	for v.pos < len(v.data) && v.data[v.pos] >= '0' && v.data[v.pos] <= '9' {
+		d := int(v.data[v.pos] - '0')
+		if n > (maxInt-d)/10 {
+			return 0, syntaxErrorf(int64(start), `number out of range`)
+		}
+		n = n*10 + d
+		v.pos++
+	}
+	if v.pos == start {
+		return 0, v.syntaxError(`expected digit`)
+	}
+	return n, v.expect(sep)
+}
+
+// integer checks a signed 64-bit decimal followed by a semicolon.
+func (v *validator) integer() error {
+	start := v.pos
+	if v.pos < len(v.data) && (v.data[v.pos] == '-' || v.data[v.pos] == '+') {
+		v.pos++
+	}
+	digits := v.pos
+	var n uint64
+	for v.pos < len(v.data) && v.data[v.pos] >= '0' && v.data[v.pos] <= '9' {
+		d := uint64(v.data[v.pos] - '0')
+		if n > (1<<63-d)/10 {
+			return syntaxErrorf(int64(start), `integer out of range`)
+		}
+		n = n*10 + d
+		v.pos++
+	}
+	if v.pos == digits {
+		return v.syntaxError(`expected digit`)
+	}
+	if n == 1<<63 && v.data[start] != '-' {
+		return syntaxErrorf(int64(start), `integer out of range`)
+	}
+	return v.expect(';')
+}
+
+// validFloat reports whether b is a spelling PHP accepts for a float.
+func validFloat(b []byte) bool {
+	v := validator{data: b}
+	return v.floatText() == nil && v.pos == len(b)
+}
+
+// float checks a float followed by a semicolon.
+func (v *validator) float() error {
+	if err := v.floatText(); err != nil {
+		return err
+	}
+	return v.expect(';')
+}
+
+// floatText checks any spelling PHP accepts for a float: integers,
+// decimals with or without digits on one side of the point, exponents,
+// INF, -INF and NAN.
+func (v *validator) floatText() error {
+	for _, special := range [...]string{`NAN`, `INF`, `-INF`} {
+		if len(v.data)-v.pos >= len(special) && string(v.data[v.pos:v.pos+len(special)]) == special {
+			v.pos += len(special)
+			return nil
+		}
+	}
+
+	if v.pos < len(v.data) && (v.data[v.pos] == '-' || v.data[v.pos] == '+') {
+		v.pos++
+	}
+	intDigits := v.digits()
+	fracDigits := 0
+	if v.pos < len(v.data) && v.data[v.pos] == '.' {
+		v.pos++
+		fracDigits = v.digits()
+	}
+	if intDigits == 0 && fracDigits == 0 {
+		return v.syntaxError(`expected digit`)
+	}
+	if v.pos < len(v.data) && (v.data[v.pos] == 'e' || v.data[v.pos] == 'E') {
+		v.pos++
+		if v.pos < len(v.data) && (v.data[v.pos] == '-' || v.data[v.pos] == '+') {
+			v.pos++
+		}
+		if v.digits() == 0 {
+			return v.syntaxError(`expected digit`)
+		}
+	}
+	return nil
+}
+
+func (v *validator) digits() int {
+	start := v.pos
+	for v.pos < len(v.data) && v.data[v.pos] >= '0' && v.data[v.pos] <= '9' {
+		v.pos++
+	}
+	return v.pos - start
+}
+
+func (v *validator) expect(expected ...byte) error {
+	for _, e := range expected {
+		if v.pos >= len(v.data) {
+			return v.eof()
+		}
+		if v.data[v.pos] != e {
+			return syntaxErrorf(int64(v.pos), `expected byte '%c' found '%c'`, e, v.data[v.pos])
+		}
+		v.pos++
+	}
+	return nil
+}
+
+func (v *validator) eof() error {
+	return syntaxErrorf(int64(v.pos), `unexpected end of input`)
+}
+
+func (v *validator) syntaxError(msg string) error {
+	return syntaxErrorf(int64(v.pos), `%s`, msg)
+}
+
+const maxInt = int(^uint(0) >> 1)
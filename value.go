@@ -0,0 +1,601 @@
+package phpserialize
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+var treeType = reflect.TypeOf((*Value)(nil)).Elem()
+
+// Kind is the kind of a serialized value held by a Value.
+type Kind int
+
+const (
+	KindNull Kind = iota
+	KindBool
+	KindInt
+	KindFloat
+	KindString
+	KindArray
+	// KindObject is an O: object with a class and properties.
+	KindObject
+	// KindCustom is a C: object whose payload was written by
+	// Serializable::serialize.
+	KindCustom
+	// KindRef is an r: or R: back reference to an earlier value.
+	KindRef
+)
+
+var kindNames = [...]string{
+	KindNull:   `null`,
+	KindBool:   `bool`,
+	KindInt:    `int`,
+	KindFloat:  `float`,
+	KindString: `string`,
+	KindArray:  `array`,
+	KindObject: `object`,
+	KindCustom: `custom object`,
+	KindRef:    `reference`,
+}
+
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return `Kind(` + strconv.Itoa(int(k)) + `)`
+}
+
+// Value is a decoded serialized value that keeps everything needed to
+// encode it again byte for byte: key order, integer and string keys, the
+// text of numbers as written, class names and references. The zero Value
+// is null.
+//
+// References point at the Value they refer to rather than at a slot
+// number, so they keep doing so when elements are added or removed. They
+// are numbered again when encoded, which fails if the value referred to
+// is no longer written before the reference.
+type Value struct {
+	// code is the serialized type code, 0 for the zero Value.
+	code byte
+	// text holds the serialized text of scalars and references, the
+	// contents of strings and the payload of custom objects.
+	text  string
+	class string
+	keys  []Value
+	elems []*Value
+	// number is set for floats created by FloatValue, which the Encoder
+	// formats from their value instead of writing text verbatim.
+	number bool
+	// target is the value a reference points at, found when the tree was
+	// decoded, or nil when its slot was out of range.
+	target *Value
+}
+
+// NullValue returns a new null Value.
+func NullValue() *Value {
+	return &Value{code: 'N'}
+}
+
+// BoolValue returns a new bool Value.
+func BoolValue(b bool) *Value {
+	if b {
+		return &Value{code: 'b', text: `1`}
+	}
+	return &Value{code: 'b', text: `0`}
+}
+
+// IntValue returns a new integer Value.
+func IntValue(n int64) *Value {
+	return &Value{code: 'i', text: strconv.FormatInt(n, 10)}
+}
+
+// FloatValue returns a new float Value, which the Encoder formats like
+// float64 values, following SetSerializePrecision if set.
+func FloatValue(f float64) *Value {
+	return &Value{code: 'd', text: formatFloat(f), number: true}
+}
+
+// StringValue returns a new string Value.
+func StringValue(s string) *Value {
+	return &Value{code: 's', text: s}
+}
+
+// ArrayValue returns a new empty array Value.
+func ArrayValue() *Value {
+	return &Value{code: 'a'}
+}
+
+// ObjectValue returns a new object Value of class without properties.
+func ObjectValue(class string) *Value {
+	return &Value{code: 'O', class: class}
+}
+
+// Kind returns the kind of v.
+func (v *Value) Kind() Kind {
+	switch v.code {
+	case 'b':
+		return KindBool
+	case 'i':
+		return KindInt
+	case 'd':
+		return KindFloat
+	case 's':
+		return KindString
+	case 'a':
+		return KindArray
+	case 'O':
+		return KindObject
+	case 'C':
+		return KindCustom
+	case 'r', 'R':
+		return KindRef
+	}
+	return KindNull
+}
+
+// Bool returns the value of a bool, false for any other kind.
+func (v *Value) Bool() bool {
+	return v.code == 'b' && v.text != `0`
+}
+
+// Int returns the value of an integer or bool and the slot a reference
+// was decoded with, 0 for any other kind.
+func (v *Value) Int() int64 {
+	switch v.code {
+	case 'i', 'b', 'r', 'R':
+		n, _ := strconv.ParseInt(v.text, 10, 64)
+		return n
+	}
+	return 0
+}
+
+// Float returns the value of a float or integer, 0 for any other kind.
+func (v *Value) Float() float64 {
+	switch v.code {
+	case 'd', 'i':
+		f, _ := strconv.ParseFloat(v.text, 64)
+		return f
+	}
+	return 0
+}
+
+// Str returns the contents of a string or the payload of a custom object.
+// For other scalars it returns their serialized text, e.g. 0.5 for d:0.5;.
+func (v *Value) Str() string {
+	return v.text
+}
+
+// Class returns the class name of an object, or an empty string.
+func (v *Value) Class() string {
+	return v.class
+}
+
+// ByReference reports whether a reference is an R: reference to a
+// variable rather than an r: reference to an object.
+func (v *Value) ByReference() bool {
+	return v.code == 'R'
+}
+
+// Len returns the number of elements of an array or properties of an
+// object.
+func (v *Value) Len() int {
+	return len(v.elems)
+}
+
+// Keys returns the keys of an array or object in order. Each key is an
+// integer or a string Value.
+func (v *Value) Keys() []Value {
+	return append([]Value(nil), v.keys...)
+}
+
+// Index returns the i-th element of an array or object in serialized
+// order, or nil if there is none.
+func (v *Value) Index(i int) *Value {
+	if i < 0 || i >= len(v.elems) {
+		return nil
+	}
+	return v.elems[i]
+}
+
+// Get returns the element of an array or object stored under key, which is
+// an int, int64 or string, or nil if there is none. Like PHP, a decimal
+// string key matches the integer key. Object properties are also found by
+// their name without visibility mangling.
+func (v *Value) Get(key interface{}) *Value {
+	if i := v.find(key); i >= 0 {
+		return v.elems[i]
+	}
+	return nil
+}
+
+// Set stores elem under key, replacing any existing element in place and
+// appending otherwise. Like assigning to a PHP variable, replacing an
+// element copies elem into it, so references to the element see the new
+// value. It panics if v is not an array or object or the key is not an
+// int, int64 or string.
+func (v *Value) Set(key interface{}, elem *Value) {
+	v.mustContainer(`Set`)
+	if i := v.find(key); i >= 0 {
+		if v.elems[i] == nil || elem == nil {
+			v.elems[i] = elem
+		} else {
+			*v.elems[i] = *elem
+		}
+		return
+	}
+	v.keys = append(v.keys, arrayKey(key))
+	v.elems = append(v.elems, elem)
+}
+
+// Append stores elem under the next integer key, one greater than the
+// largest integer key, like $a[] = $elem does in PHP.
+func (v *Value) Append(elem *Value) {
+	v.mustContainer(`Append`)
+	var next int64
+	for i := range v.keys {
+		if v.keys[i].code == 'i' {
+			if n := v.keys[i].Int(); n >= next {
+				next = n + 1
+			}
+		}
+	}
+	v.Set(next, elem)
+}
+
+// Delete removes the element stored under key and reports whether it was
+// present.
+func (v *Value) Delete(key interface{}) bool {
+	i := v.find(key)
+	if i < 0 {
+		return false
+	}
+	v.keys = append(v.keys[:i], v.keys[i+1:]...)
+	v.elems = append(v.elems[:i], v.elems[i+1:]...)
+	return true
+}
+
+func (v *Value) mustContainer(method string) {
+	if v.code != 'a' && v.code != 'O' {
+		panic(`phpserialize: Value.` + method + ` on ` + v.Kind().String())
+	}
+}
+
+func (v *Value) find(key interface{}) int {
+	k := arrayKey(key)
+	for i := range v.keys {
+		if v.keys[i].code == k.code && (v.keys[i].text == k.text || k.code == 'i' && v.keys[i].Int() == k.Int()) {
+			return i
+		}
+	}
+	if v.code == 'O' && k.code == 's' {
+		for i := range v.keys {
+			if v.keys[i].code == 's' && unmangledName(v.keys[i].text) == k.text {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// arrayKey converts key to the integer or string key PHP would store it
+// under.
+func arrayKey(key interface{}) Value {
+	switch k := key.(type) {
+	case int:
+		return *IntValue(int64(k))
+	case int64:
+		return *IntValue(k)
+	case string:
+		if n, ok := integerKey(k); ok {
+			return *IntValue(n)
+		}
+		return *StringValue(k)
+	}
+	panic(`phpserialize: invalid array key type ` + reflect.TypeOf(key).String())
+}
+
+func decodeTreeValue(d *Decoder, v reflect.Value) error {
+	tree, err := d.decodeTree()
+	if err != nil {
+		return err
+	}
+	// The references are linked once the value is in place, so those to
+	// the top-level value point at it rather than at a copy.
+	v.Set(reflect.ValueOf(*tree))
+	linkRefs(v.Addr().Interface().(*Value))
+	return nil
+}
+
+// DecodeTree decodes the next value into a Value. Its references are
+// resolved counting slots from the value itself, as if it was decoded on
+// its own.
+func (d *Decoder) DecodeTree() (*Value, error) {
+	v, err := d.decodeTree()
+	if err != nil {
+		return nil, err
+	}
+	linkRefs(v)
+	return v, nil
+}
+
+func (d *Decoder) decodeTree() (*Value, error) {
+	offset := d.offset
+	code, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Value{code: code}
+	switch code {
+	case 'N':
+		return v, d.DecodeNil()
+	case 'b', 'i', 'd', 'r', 'R':
+		if err := d.skipExpected(code, ':'); err != nil {
+			return nil, err
+		}
+		text, err := d.readUntil(';')
+		if err != nil {
+			return nil, err
+		}
+		v.text = string(text)
+		if !validScalarText(code, v.text) {
+			return nil, syntaxErrorf(offset, `invalid %s %q`, phpValueName(code), text)
+		}
+		return v, nil
+	case 's':
+		v.text, err = d.DecodeString()
+		return v, err
+	case 'a':
+		n, err := d.DecodeArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		return v, d.decodeTreeElements(v, n)
+	case 'O':
+		class, n, err := d.readObjectHeader()
+		if err != nil {
+			return nil, err
+		}
+		if err := d.checkClass(class, offset, true); err != nil {
+			return nil, err
+		}
+		v.class = class
+		return v, d.decodeTreeElements(v, n)
+	case 'C':
+		class, n, err := d.readCustomHeader()
+		if err != nil {
+			return nil, err
+		}
+		if err := d.checkClass(class, offset, true); err != nil {
+			return nil, err
+		}
+		data, err := d.readBytes(n)
+		if err != nil {
+			return nil, err
+		}
+		v.class = class
+		v.text = string(data)
+		return v, d.skipExpected('}')
+	}
+
+	return nil, syntaxErrorf(offset, `unexpected code '%c'`, code)
+}
+
+func (d *Decoder) decodeTreeElements(v *Value, n int) error {
+	v.keys = make([]Value, 0, min(n, sliceAllocLimit))
+	v.elems = make([]*Value, 0, min(n, sliceAllocLimit))
+	for i := 0; i < n; i++ {
+		offset := d.offset
+		key, err := d.decodeTree()
+		if err != nil {
+			return err
+		}
+		if key.code != 'i' && key.code != 's' {
+			return syntaxErrorf(offset, `invalid array key '%c'`, key.code)
+		}
+		elem, err := d.decodeTree()
+		if err != nil {
+			return err
+		}
+		v.keys = append(v.keys, *key)
+		v.elems = append(v.elems, elem)
+	}
+	return d.DecodeArrayEnd()
+}
+
+func validScalarText(code byte, text string) bool {
+	var err error
+	switch code {
+	case 'b':
+		return text == `0` || text == `1`
+	case 'd':
+		return validFloat([]byte(text))
+	default:
+		_, err = strconv.ParseInt(text, 10, 64)
+	}
+	return err == nil
+}
+
+// refLinker resolves the references within a value.
+type refLinker struct {
+	// values holds the values a reference may point at in order, like the
+	// variable table PHP's unserialize keeps.
+	values []*Value
+}
+
+// linkRefs points the references within v at the values they refer to.
+func linkRefs(v *Value) {
+	var l refLinker
+	l.link(v)
+}
+
+func (l *refLinker) link(v *Value) {
+	if v.code == 'r' || v.code == 'R' {
+		if n, err := strconv.Atoi(v.text); err == nil && n >= 1 && n <= len(l.values) {
+			v.target = l.values[n-1]
+		}
+	}
+	if v.code != 'R' {
+		l.values = append(l.values, v)
+	}
+	for _, elem := range v.elems {
+		l.link(elem)
+	}
+}
+
+func encodeTreeValue(e *Encoder, v reflect.Value) error {
+	if v.CanAddr() {
+		return e.EncodeTree(v.Addr().Interface().(*Value))
+	}
+	tree := v.Interface().(Value)
+	t := newTreeEncoder(e, &tree)
+	t.root = &tree
+	return t.encode(&tree)
+}
+
+// EncodeTree writes v exactly as it was decoded, or as built when it was
+// created or modified in Go. References are numbered for the slots the
+// values they point at take in v.
+func (e *Encoder) EncodeTree(v *Value) error {
+	t := newTreeEncoder(e, v)
+	return t.encode(v)
+}
+
+// treeEncoder writes a Value, numbering its references.
+type treeEncoder struct {
+	e *Encoder
+	// root is a copy of the Value being written, whose references still
+	// point at the original.
+	root *Value
+	// slots holds the slots taken by the values written so far, and n
+	// counts them. It is nil when there are no references to number.
+	slots map[*Value]int
+	n     int
+	// verbatim writes references with the slot they were decoded with.
+	verbatim bool
+}
+
+func newTreeEncoder(e *Encoder, v *Value) *treeEncoder {
+	t := &treeEncoder{e: e}
+	if hasTargets(v) {
+		t.slots = make(map[*Value]int)
+	}
+	return t
+}
+
+// hasTargets reports whether v holds references that point at a value.
+func hasTargets(v *Value) bool {
+	if v == nil {
+		return false
+	}
+	if v.target != nil {
+		return true
+	}
+	for _, elem := range v.elems {
+		if hasTargets(elem) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *treeEncoder) encode(v *Value) error {
+	e := t.e
+	if v == nil {
+		t.n++
+		return e.EncodeNil()
+	}
+	if v.code != 'R' {
+		t.n++
+		if _, ok := t.slots[v]; t.slots != nil && !ok {
+			t.slots[v] = t.n
+		}
+	}
+
+	if v.number {
+		return e.EncodeFloat64(v.Float())
+	}
+
+	switch v.code {
+	case 'r', 'R':
+		if v.target == nil || t.verbatim || t.slots == nil {
+			return e.encodeTreeScalar(v)
+		}
+		n, ok := t.slot(v.target)
+		if !ok {
+			return fmt.Errorf(`phpserialize: EncodeTree(reference to a value that is not written before it)`)
+		}
+		if err := e.writeBytes(v.code, ':'); err != nil {
+			return err
+		}
+		if err := e.writeInt(n); err != nil {
+			return err
+		}
+		return e.writeBytes(';')
+	case 'b', 'i', 'd':
+		return e.encodeTreeScalar(v)
+	case 's':
+		return e.EncodeString(v.text)
+	case 'a':
+		if err := e.EncodeArrayLen(len(v.elems)); err != nil {
+			return err
+		}
+		return t.encodeElements(v)
+	case 'O':
+		if err := e.EncodeObjectLen(v.class, len(v.elems)); err != nil {
+			return err
+		}
+		return t.encodeElements(v)
+	case 'C':
+		return e.encodeCustomObject(v.class, []byte(v.text))
+	}
+	return e.EncodeNil()
+}
+
+// slot returns the slot of target among the values written so far.
+func (t *treeEncoder) slot(target *Value) (int, bool) {
+	n, ok := t.slots[target]
+	if !ok && t.root != nil && sameContainer(target, t.root) {
+		n, ok = t.slots[t.root]
+	}
+	return n, ok
+}
+
+// sameContainer reports whether a and b are copies of the same array or
+// object, sharing their elements.
+func sameContainer(a, b *Value) bool {
+	return a.code == b.code && len(a.elems) > 0 && len(a.elems) == len(b.elems) && &a.elems[0] == &b.elems[0]
+}
+
+// encodeElements writes the elements of an array or object. Keys take no
+// slot.
+func (t *treeEncoder) encodeElements(v *Value) error {
+	for i, elem := range v.elems {
+		key := &v.keys[i]
+		var err error
+		if key.code == 's' {
+			err = t.e.EncodeString(key.text)
+		} else {
+			err = t.e.encodeTreeScalar(key)
+		}
+		if err != nil {
+			return err
+		}
+		if err := t.encode(elem); err != nil {
+			return err
+		}
+	}
+	return t.e.writeBytes('}')
+}
+
+// encodeTreeScalar writes a scalar or reference with its text as decoded.
+func (e *Encoder) encodeTreeScalar(v *Value) error {
+	if err := e.writeBytes(v.code, ':'); err != nil {
+		return err
+	}
+	if err := e.writeString(v.text); err != nil {
+		return err
+	}
+	return e.writeBytes(';')
+}
@@ -0,0 +1,273 @@
+package phpserialize
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// The functions in this file walk serialized input held in memory without
+// decoding it, for queries and edits that only touch part of the data.
+// Offsets are indexes into the byte slice.
+
+// rawHeader describes the header of an a: or O: value.
+type rawHeader struct {
+	// class is the class name of an object.
+	class []byte
+	// countStart and countEnd delimit the element count.
+	countStart, countEnd int
+	n                    int
+	// body is the offset of the first key, just past the opening brace.
+	body int
+}
+
+// rawLen reads the decimal length ending in sep that starts at pos and
+// returns it with the offset past sep.
+func rawLen(data []byte, pos int, sep byte) (int, int, error) {
+	i := bytes.IndexByte(data[min(pos, len(data)):], sep)
+	if i < 0 || i > maxNumberLen {
+		return 0, 0, syntaxErrorf(int64(pos), `expected byte '%c'`, sep)
+	}
+	n, err := strconv.Atoi(string(data[pos : pos+i]))
+	if err != nil || data[pos] < '0' || data[pos] > '9' {
+		return 0, 0, syntaxErrorf(int64(pos), `invalid length %q`, data[pos:pos+i])
+	}
+	return n, pos + i + 1, nil
+}
+
+// rawExpect checks that data holds s at pos and returns the offset past it.
+func rawExpect(data []byte, pos int, s string) (int, error) {
+	for i := 0; i < len(s); i++ {
+		if pos+i >= len(data) {
+			return 0, syntaxErrorf(int64(pos+i), `unexpected end of input`)
+		}
+		if data[pos+i] != s[i] {
+			return 0, syntaxErrorf(int64(pos+i), `expected byte '%c' found '%c'`, s[i], data[pos+i])
+		}
+	}
+	return pos + len(s), nil
+}
+
+// rawQuoted reads a length prefixed quoted byte sequence such as 5:"Hello"
+// starting at pos.
+func rawQuoted(data []byte, pos int) ([]byte, int, error) {
+	n, pos, err := rawLen(data, pos, ':')
+	if err != nil {
+		return nil, 0, err
+	}
+	if pos, err = rawExpect(data, pos, `"`); err != nil {
+		return nil, 0, err
+	}
+	if n > len(data)-pos {
+		return nil, 0, syntaxErrorf(int64(pos), `string of length %d exceeds input`, n)
+	}
+	s := data[pos : pos+n]
+	if pos, err = rawExpect(data, pos+n, `"`); err != nil {
+		return nil, 0, err
+	}
+	return s, pos, nil
+}
+
+// rawString reads the s: value starting at pos and returns its contents.
+func rawString(data []byte, pos int) ([]byte, int, error) {
+	pos, err := rawExpect(data, pos, `s:`)
+	if err != nil {
+		return nil, 0, err
+	}
+	s, pos, err := rawQuoted(data, pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	pos, err = rawExpect(data, pos, `;`)
+	return s, pos, err
+}
+
+// rawScalar reads the text of the b:, i:, d:, r: or R: value starting at
+// pos.
+func rawScalar(data []byte, pos int) ([]byte, int, error) {
+	if pos+2 > len(data) || data[pos+1] != ':' {
+		return nil, 0, syntaxErrorf(int64(pos), `expected byte ':'`)
+	}
+	start := pos + 2
+	i := bytes.IndexByte(data[start:], ';')
+	if i < 0 || i > maxNumberLen {
+		return nil, 0, syntaxErrorf(int64(start), `expected byte ';'`)
+	}
+	return data[start : start+i], start + i + 1, nil
+}
+
+// rawContainer reads the header of the a: or O: value starting at pos.
+func rawContainer(data []byte, pos int) (rawHeader, error) {
+	var h rawHeader
+	var err error
+	switch {
+	case pos < len(data) && data[pos] == 'a':
+		if pos, err = rawExpect(data, pos, `a:`); err != nil {
+			return h, err
+		}
+	case pos < len(data) && data[pos] == 'O':
+		if pos, err = rawExpect(data, pos, `O:`); err != nil {
+			return h, err
+		}
+		if h.class, pos, err = rawQuoted(data, pos); err != nil {
+			return h, err
+		}
+		if pos, err = rawExpect(data, pos, `:`); err != nil {
+			return h, err
+		}
+	default:
+		return h, syntaxErrorf(int64(pos), `expected array or object`)
+	}
+	h.countStart = pos
+	if h.n, pos, err = rawLen(data, pos, ':'); err != nil {
+		return h, err
+	}
+	h.countEnd = pos - 1
+	if h.body, err = rawExpect(data, pos, `{`); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// rawKey reads the i: or s: key starting at pos and returns its text.
+func rawKey(data []byte, pos int) ([]byte, int, error) {
+	if pos < len(data) {
+		switch data[pos] {
+		case 'i':
+			return rawScalar(data, pos)
+		case 's':
+			return rawString(data, pos)
+		}
+	}
+	if pos >= len(data) {
+		return nil, 0, syntaxErrorf(int64(pos), `unexpected end of input`)
+	}
+	return nil, 0, syntaxErrorf(int64(pos), `invalid array key '%c'`, data[pos])
+}
+
+// rawValueEnd returns the offset just past the value starting at pos.
+func rawValueEnd(data []byte, pos int) (int, error) {
+	return rawValueEndDepth(data, pos, 0)
+}
+
+func rawValueEndDepth(data []byte, pos, depth int) (int, error) {
+	if pos >= len(data) {
+		return 0, syntaxErrorf(int64(pos), `unexpected end of input`)
+	}
+
+	switch data[pos] {
+	case 'N':
+		return rawExpect(data, pos, `N;`)
+	case 'b', 'i', 'd', 'r', 'R':
+		_, end, err := rawScalar(data, pos)
+		return end, err
+	case 's':
+		_, end, err := rawString(data, pos)
+		return end, err
+	case 'a', 'O':
+		if depth >= DefaultMaxDepth {
+			return 0, &LimitError{Limit: `depth`, Max: DefaultMaxDepth, Offset: int64(pos)}
+		}
+		h, err := rawContainer(data, pos)
+		if err != nil {
+			return 0, err
+		}
+		pos = h.body
+		for i := 0; i < h.n; i++ {
+			if _, pos, err = rawKey(data, pos); err != nil {
+				return 0, err
+			}
+			if pos, err = rawValueEndDepth(data, pos, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		return rawExpect(data, pos, `}`)
+	case 'C':
+		pos, err := rawExpect(data, pos, `C:`)
+		if err != nil {
+			return 0, err
+		}
+		if _, pos, err = rawQuoted(data, pos); err != nil {
+			return 0, err
+		}
+		if pos, err = rawExpect(data, pos, `:`); err != nil {
+			return 0, err
+		}
+		n, pos, err := rawLen(data, pos, ':')
+		if err != nil {
+			return 0, err
+		}
+		if pos, err = rawExpect(data, pos, `{`); err != nil {
+			return 0, err
+		}
+		if n > len(data)-pos {
+			return 0, syntaxErrorf(int64(pos), `object of length %d exceeds input`, n)
+		}
+		return rawExpect(data, pos+n, `}`)
+	}
+
+	return 0, syntaxErrorf(int64(pos), `unexpected code '%c'`, data[pos])
+}
+
+// rawRef is an r: or R: value.
+type rawRef struct {
+	// start and end delimit the slot number the reference points at.
+	start, end int
+	n          int
+}
+
+// rawSlots walks the value at the start of data and returns the offsets of
+// the values that take a slot in the table references point into, in slot
+// order, along with the references it holds.
+func rawSlots(data []byte) ([]int, []rawRef, error) {
+	var (
+		slots []int
+		refs  []rawRef
+	)
+	if _, err := rawSlotsDepth(data, 0, 0, &slots, &refs); err != nil {
+		return nil, nil, err
+	}
+	return slots, refs, nil
+}
+
+func rawSlotsDepth(data []byte, pos, depth int, slots *[]int, refs *[]rawRef) (int, error) {
+	if pos >= len(data) {
+		return 0, syntaxErrorf(int64(pos), `unexpected end of input`)
+	}
+	// Like PHP, every value but an R: reference takes a slot.
+	if data[pos] != 'R' {
+		*slots = append(*slots, pos)
+	}
+
+	switch data[pos] {
+	case 'r', 'R':
+		text, end, err := rawScalar(data, pos)
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.Atoi(string(text))
+		if err != nil {
+			return 0, syntaxErrorf(int64(pos), `invalid reference %q`, text)
+		}
+		*refs = append(*refs, rawRef{start: pos + 2, end: end - 1, n: n})
+		return end, nil
+	case 'a', 'O':
+		if depth >= DefaultMaxDepth {
+			return 0, &LimitError{Limit: `depth`, Max: DefaultMaxDepth, Offset: int64(pos)}
+		}
+		h, err := rawContainer(data, pos)
+		if err != nil {
+			return 0, err
+		}
+		pos = h.body
+		for i := 0; i < h.n; i++ {
+			if _, pos, err = rawKey(data, pos); err != nil {
+				return 0, err
+			}
+			if pos, err = rawSlotsDepth(data, pos, depth+1, slots, refs); err != nil {
+				return 0, err
+			}
+		}
+		return rawExpect(data, pos, `}`)
+	}
+	return rawValueEnd(data, pos)
+}